@@ -9,7 +9,8 @@ import (
 )
 
 var (
-	RepoConfigs []repository.RepoConfig
+	RepoConfigs  []repository.RepoConfig
+	GlobalConfig repository.GlobalConfig
 )
 
 func LoadConfig() error {
@@ -29,10 +30,16 @@ func LoadConfig() error {
 		}
 	}
 
+	RepoConfigs = nil
 	if err := viper.UnmarshalKey("repositories", &RepoConfigs); err != nil {
 		return errors.New("unable to decode repo configs")
 	}
 
+	GlobalConfig = repository.GlobalConfig{}
+	if err := viper.Unmarshal(&GlobalConfig); err != nil {
+		return errors.New("unable to decode global config")
+	}
+
 	if len(RepoConfigs) == 0 {
 		return errors.New("no repo configs found")
 	}
@@ -41,10 +48,19 @@ func LoadConfig() error {
 	validate.RegisterValidation("repo_id", repository.ValidateId)
 	validate.RegisterValidation("repo_path", repository.ValidatePathAbs)
 	validate.RegisterValidation("repo_cron", repository.ValidateCron)
+	validate.RegisterValidation("bw_rate", repository.ValidateRate)
+	validate.RegisterValidation("bw_clock", repository.ValidateClock)
+	validate.RegisterValidation("rsync_filter", repository.ValidateFilter)
+	validate.RegisterValidation("repo_duration", repository.ValidateDuration)
+	validate.RegisterValidation("repo_channel", repository.ValidateChannel)
 
 	if err := validate.Var(&RepoConfigs, "dive"); err != nil {
 		return errors.Errorf("missing required repo config attributes %v", err)
 	}
 
+	if err := validate.Struct(&GlobalConfig); err != nil {
+		return errors.Errorf("invalid global config attributes %v", err)
+	}
+
 	return nil
 }
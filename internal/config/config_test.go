@@ -107,6 +107,303 @@ repositories:
 	}
 }
 
+func TestLoadConfigPackageFilter(t *testing.T) {
+	defer removeConfigFile()
+
+	config := `
+---
+repositories:
+  - id: appstream
+    name: AppStream
+    type: reposync
+    src: |
+      [appstream]
+      baseurl=https://repo.example.com/8/AppStream/x86_64/os/
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+    include:
+      - nginx*
+    arch:
+      - x86_64
+    keep_versions: 3
+`
+
+	if err := writeConfigFile(config); err == nil {
+		if err := LoadConfig(); err != nil || len(RepoConfigs) != 1 {
+			t.Fatalf("Config file with package filter should not result in error; %v", err)
+		}
+
+		if RepoConfigs[0].KeepVersions != 3 || len(RepoConfigs[0].Include) != 1 || len(RepoConfigs[0].Arch) != 1 {
+			t.Errorf("Package filter not decoded correctly: %+v", RepoConfigs[0])
+		}
+	} else {
+		t.Errorf("Cannot write config file %v", err)
+	}
+}
+
+func TestLoadConfigBandwidth(t *testing.T) {
+	defer removeConfigFile()
+
+	config := `
+---
+bandwidth:
+  limit: 100mbit
+  schedule:
+    - from: "08:00"
+      to: "18:00"
+      days: [mon, tue, wed, thu, fri]
+      limit: 20mbit
+repositories:
+  - id: dummy1
+    name: Dummy Mirror - 1
+    type: dummy
+    src: None
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+    bandwidth:
+      limit: 5MB
+`
+
+	if err := writeConfigFile(config); err == nil {
+		if err := LoadConfig(); err != nil {
+			t.Fatalf("Config file with bandwidth limits should not result in error; %v", err)
+		}
+
+		if GlobalConfig.Bandwidth.Limit != "100mbit" || len(GlobalConfig.Bandwidth.Schedule) != 1 || RepoConfigs[0].Bandwidth.Limit != "5MB" {
+			t.Errorf("Bandwidth limits not decoded correctly: %+v %+v", GlobalConfig, RepoConfigs[0])
+		}
+	} else {
+		t.Errorf("Cannot write config file %v", err)
+	}
+}
+
+func TestLoadConfigInvalidBandwidth(t *testing.T) {
+	defer removeConfigFile()
+
+	config := `
+---
+bandwidth:
+  schedule:
+    - from: "8 o'clock"
+      to: "18:00"
+      limit: 20mbit
+repositories:
+  - id: dummy1
+    name: Dummy Mirror - 1
+    type: dummy
+    src: None
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+`
+
+	if err := writeConfigFile(config); err == nil {
+		if err := LoadConfig(); err == nil {
+			t.Errorf("Config file with invalid bandwidth schedule should result in error")
+		}
+	} else {
+		t.Errorf("Cannot write config file %v", err)
+	}
+}
+
+func TestLoadConfigCredentials(t *testing.T) {
+	defer removeConfigFile()
+
+	config := `
+---
+repositories:
+  - id: private
+    name: Private Mirror
+    type: rsync
+    src: rsync://mirror.example.com/private/
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+    credentials:
+      username: mirror
+      password:
+        env: LAGOON_PRIVATE_PASSWORD
+`
+
+	if err := writeConfigFile(config); err == nil {
+		if err := LoadConfig(); err != nil {
+			t.Fatalf("Config file with credentials should not result in error; %v", err)
+		}
+
+		creds := RepoConfigs[0].Credentials
+		if creds.Username != "mirror" || creds.Password.Env != "LAGOON_PRIVATE_PASSWORD" {
+			t.Errorf("Credentials not decoded correctly: %+v", creds)
+		}
+	} else {
+		t.Errorf("Cannot write config file %v", err)
+	}
+}
+
+func TestLoadConfigVerify(t *testing.T) {
+	defer removeConfigFile()
+
+	var tests = []struct {
+		verify string
+		valid  bool
+	}{
+		{"verify:\n      keyrings: [/etc/pki/rpm-gpg/RPM-GPG-KEY-rockyofficial]\n      metadata: true\n      packages: true", true},
+		{"verify:\n      keyrings: [/etc/pki/rpm-gpg/RPM-GPG-KEY-rockyofficial]", true},
+		{"verify:\n      metadata: true", false},
+		{"verify:\n      keyrings: [relative/key.gpg]\n      packages: true", false},
+	}
+	for i, test := range tests {
+		config := `
+---
+repositories:
+  - id: rocky
+    name: Rocky Linux
+    type: rsync
+    src: rsync://mirror.example.com/rocky/
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+    ` + test.verify + `
+`
+
+		if err := writeConfigFile(config); err != nil {
+			t.Fatalf("Cannot write config file %v", err)
+		}
+
+		err := LoadConfig()
+		if test.valid && err != nil {
+			t.Errorf("Test: %d with valid verify config should not result in error: %v", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Test: %d with invalid verify config should result in error", i)
+		}
+	}
+}
+
+func TestLoadConfigFilters(t *testing.T) {
+	defer removeConfigFile()
+
+	var tests = []struct {
+		filters string
+		valid   bool
+	}{
+		{"[\"include /7/\", \"include /7/os/***\", \"exclude *\"]", true},
+		{"[\"merge /etc/lagoon/centos.rules\", \"P /local/\"]", true},
+		{"[\"include /7/\", \"exclud *\"]", false},
+		{"[\"merge centos.rules\"]", false},
+	}
+	for i, test := range tests {
+		config := `
+---
+repositories:
+  - id: centos
+    name: CentOS
+    type: rsync
+    src: rsync://mirror.example.com/centos/
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+    delete_excluded: true
+    filters: ` + test.filters + `
+`
+
+		if err := writeConfigFile(config); err != nil {
+			t.Fatalf("Cannot write config file %v", err)
+		}
+
+		err := LoadConfig()
+		if test.valid {
+			if err != nil {
+				t.Errorf("Test: %d with valid filters should not result in error: %v", i, err)
+			} else if !RepoConfigs[0].DeleteExcluded {
+				t.Errorf("Test: %d delete_excluded not decoded", i)
+			}
+		} else if err == nil {
+			t.Errorf("Test: %d with invalid filters should result in error", i)
+		}
+	}
+}
+
+func TestLoadConfigKeepDeleted(t *testing.T) {
+	defer removeConfigFile()
+
+	var tests = []struct {
+		options string
+		valid   bool
+	}{
+		{"keep_deleted: 90d", true},
+		{"keep_deleted: 36h", true},
+		{"keep_deleted: 90 days", false},
+		{"keep_deleted: 90d\n    delete_excluded: true", false},
+	}
+	for i, test := range tests {
+		config := `
+---
+repositories:
+  - id: vendor
+    name: Vendor
+    type: rsync
+    src: rsync://mirror.example.com/vendor/
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    snapshots: 52
+    ` + test.options + `
+`
+
+		if err := writeConfigFile(config); err != nil {
+			t.Fatalf("Cannot write config file %v", err)
+		}
+
+		err := LoadConfig()
+		if test.valid && err != nil {
+			t.Errorf("Test: %d with valid keep_deleted should not result in error: %v", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Test: %d with invalid keep_deleted should result in error", i)
+		}
+	}
+}
+
+func TestLoadConfigRetention(t *testing.T) {
+	defer removeConfigFile()
+
+	var tests = []struct {
+		options string
+		valid   bool
+	}{
+		{"snapshots: 52", true},
+		{"retention: {daily: 14, monthly: 12}", true},
+		{"retention: {weekly: 4, max_age: 1y}", false},
+		{"retention: {yearly: 5, max_age: 400d, min_count: 3}", true},
+		{"retention: {daily: -1}", false},
+		{"snapshots: 52\n    retention: {daily: 14}", false},
+		{"snapshots: 0", false},
+	}
+	for i, test := range tests {
+		config := `
+---
+repositories:
+  - id: vendor
+    name: Vendor
+    type: rsync
+    src: rsync://mirror.example.com/vendor/
+    dest: /var/lib/lagoon
+    cron: "0 0 12-14 ? * *"
+    ` + test.options + `
+`
+
+		if err := writeConfigFile(config); err != nil {
+			t.Fatalf("Cannot write config file %v", err)
+		}
+
+		err := LoadConfig()
+		if test.valid && err != nil {
+			t.Errorf("Test: %d with valid retention should not result in error: %v", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Test: %d with invalid retention should result in error", i)
+		}
+	}
+}
+
 func writeConfigFile(cfg string) error {
 	content := []byte(cfg)
 
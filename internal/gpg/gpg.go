@@ -0,0 +1,100 @@
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+var armorPrefix = []byte("-----BEGIN PGP")
+
+// ReadKeyring reads the keys of armored or binary keyring files, armored files
+// may hold several key blocks
+func ReadKeyring(paths []string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err := readKeys(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read keyring %s", path)
+		}
+
+		keyring = append(keyring, keys...)
+	}
+
+	if len(keyring) == 0 {
+		return nil, errors.New("keyring contains no keys")
+	}
+
+	return keyring, nil
+}
+
+func readKeys(data []byte) (openpgp.EntityList, error) {
+	if !isArmored(data) {
+		return openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+
+	var keys openpgp.EntityList
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		block, err := armor.Decode(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		blockKeys, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, blockKeys...)
+	}
+
+	return keys, nil
+}
+
+func isArmored(data []byte) bool {
+	return bytes.Contains(data, armorPrefix)
+}
+
+// VerifyDetached checks the armored or binary detached signature sig of signed
+func VerifyDetached(keyring openpgp.KeyRing, signed io.Reader, sig []byte) error {
+	var err error
+
+	if isArmored(sig) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, signed, bytes.NewReader(sig))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(sig))
+	}
+
+	return err
+}
+
+// VerifyClearSigned checks a clear signed message, such as a Debian InRelease
+// file, and returns the signed content
+func VerifyClearSigned(keyring openpgp.KeyRing, data []byte) ([]byte, error) {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, errors.New("no clear signed message found")
+	}
+
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+		return nil, err
+	}
+
+	return block.Plaintext, nil
+}
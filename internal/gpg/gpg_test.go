@@ -0,0 +1,132 @@
+package gpg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func newTestEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Lagoon Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entity
+}
+
+func writeTestKeyring(t *testing.T, armored bool, entities ...*openpgp.Entity) string {
+	var buf bytes.Buffer
+
+	for _, e := range entities {
+		if armored {
+			w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := e.Serialize(w); err != nil {
+				t.Fatal(err)
+			}
+
+			w.Close()
+			buf.WriteString("\n")
+		} else if err := e.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "keyring.gpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadKeyring(t *testing.T) {
+	e1 := newTestEntity(t)
+	e2 := newTestEntity(t)
+
+	for _, armored := range []bool{false, true} {
+		keyring, err := ReadKeyring([]string{writeTestKeyring(t, armored, e1, e2)})
+
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(keyring), 2)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.gpg")
+	os.WriteFile(empty, nil, 0644)
+
+	_, err := ReadKeyring([]string{empty})
+	assert.NotEqual(t, err, nil)
+
+	_, err = ReadKeyring([]string{filepath.Join(t.TempDir(), "missing.gpg")})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestVerifyDetached(t *testing.T) {
+	signer := newTestEntity(t)
+	other := newTestEntity(t)
+	data := []byte("<repomd/>")
+
+	var binarySig, armoredSig bytes.Buffer
+	openpgp.DetachSign(&binarySig, signer, bytes.NewReader(data), nil)
+	openpgp.ArmoredDetachSign(&armoredSig, signer, bytes.NewReader(data), nil)
+
+	var tests = []struct {
+		keyring openpgp.EntityList
+		data    []byte
+		sig     []byte
+		valid   bool
+	}{
+		{openpgp.EntityList{signer}, data, binarySig.Bytes(), true},
+		{openpgp.EntityList{signer}, data, armoredSig.Bytes(), true},
+		{openpgp.EntityList{other, signer}, data, armoredSig.Bytes(), true},
+		{openpgp.EntityList{other}, data, armoredSig.Bytes(), false},
+		{openpgp.EntityList{signer}, []byte("<repomd>tampered</repomd>"), armoredSig.Bytes(), false},
+		{openpgp.EntityList{signer}, data, []byte("garbage"), false},
+	}
+	for i, test := range tests {
+		err := VerifyDetached(test.keyring, bytes.NewReader(test.data), test.sig)
+
+		if test.valid && err != nil {
+			t.Errorf("Test: %d with valid signature should not result in error: %s", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Test: %d with invalid signature should result in error", i)
+		}
+	}
+}
+
+func TestVerifyClearSigned(t *testing.T) {
+	signer := newTestEntity(t)
+	content := "Origin: Debian\nSuite: stable\n"
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, signer.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	w.Close()
+
+	plaintext, err := VerifyClearSigned(openpgp.EntityList{signer}, buf.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(plaintext), content)
+
+	_, err = VerifyClearSigned(openpgp.EntityList{newTestEntity(t)}, buf.Bytes())
+	assert.NotEqual(t, err, nil)
+
+	tampered := bytes.Replace(buf.Bytes(), []byte("stable"), []byte("testing"), 1)
+	_, err = VerifyClearSigned(openpgp.EntityList{signer}, tampered)
+	assert.NotEqual(t, err, nil)
+
+	_, err = VerifyClearSigned(openpgp.EntityList{signer}, []byte(content))
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,265 @@
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+
+	// Hashes used for RPM payload digests
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+)
+
+const rpmLeadSize = 96
+
+var rpmLeadMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8}
+
+// Upper bounds to reject corrupt headers before allocating memory
+const maxRPMHeaderEntries = 0x10000
+const maxRPMHeaderSize = 256 << 20
+
+const (
+	rpmSigTagDSA = 267 // Header only
+	rpmSigTagRSA = 268 // Header only
+	rpmSigTagPGP = 1002
+	rpmSigTagGPG = 1005
+
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+)
+
+const (
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeBin         = 7
+	rpmTypeStringArray = 8
+)
+
+// OpenPGP hash algorithm ids used by RPMTAG_PAYLOADDIGESTALGO
+var rpmDigestAlgos = map[uint32]crypto.Hash{
+	1:  crypto.MD5,
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+type rpmEntry struct {
+	typ    uint32
+	offset uint32
+	count  uint32
+}
+
+// rpmHeader is a header structure of an RPM, raw holds the header as signed
+type rpmHeader struct {
+	raw     []byte
+	store   []byte
+	entries map[uint32]rpmEntry
+}
+
+func readRPMHeader(r io.Reader) (rpmHeader, error) {
+	prefix := make([]byte, 16)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return rpmHeader{}, err
+	}
+
+	if !bytes.Equal(prefix[:3], rpmHeaderMagic) {
+		return rpmHeader{}, errors.New("invalid header magic")
+	}
+
+	count := binary.BigEndian.Uint32(prefix[8:12])
+	size := binary.BigEndian.Uint32(prefix[12:16])
+	if count > maxRPMHeaderEntries || size > maxRPMHeaderSize {
+		return rpmHeader{}, errors.New("header too large")
+	}
+
+	rest := make([]byte, int(count)*16+int(size))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return rpmHeader{}, err
+	}
+
+	h := rpmHeader{
+		raw:     append(prefix, rest...),
+		store:   rest[count*16:],
+		entries: map[uint32]rpmEntry{},
+	}
+
+	for i := uint32(0); i < count; i++ {
+		entry := rest[i*16 : (i+1)*16]
+
+		h.entries[binary.BigEndian.Uint32(entry[0:4])] = rpmEntry{
+			typ:    binary.BigEndian.Uint32(entry[4:8]),
+			offset: binary.BigEndian.Uint32(entry[8:12]),
+			count:  binary.BigEndian.Uint32(entry[12:16]),
+		}
+	}
+
+	return h, nil
+}
+
+func (h rpmHeader) bin(tag uint32) ([]byte, bool) {
+	e, ok := h.entries[tag]
+	if !ok || e.typ != rpmTypeBin || uint64(e.offset)+uint64(e.count) > uint64(len(h.store)) {
+		return nil, false
+	}
+
+	return h.store[e.offset : e.offset+e.count], true
+}
+
+// str returns a string or the first string of a string array
+func (h rpmHeader) str(tag uint32) (string, bool) {
+	e, ok := h.entries[tag]
+	if !ok || (e.typ != rpmTypeString && e.typ != rpmTypeStringArray) || int(e.offset) >= len(h.store) {
+		return "", false
+	}
+
+	value := h.store[e.offset:]
+	if end := bytes.IndexByte(value, 0); end >= 0 {
+		return string(value[:end]), true
+	}
+
+	return "", false
+}
+
+func (h rpmHeader) int32(tag uint32) (uint32, bool) {
+	e, ok := h.entries[tag]
+	if !ok || e.typ != rpmTypeInt32 || int(e.offset)+4 > len(h.store) {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint32(h.store[e.offset:]), true
+}
+
+// VerifyRPM checks the signatures of the package at path. Header and payload
+// signatures are checked when present, otherwise the payload is checked
+// against the digest in the signed header.
+func VerifyRPM(keyring openpgp.KeyRing, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	lead := make([]byte, rpmLeadSize)
+	if _, err := io.ReadFull(r, lead); err != nil {
+		return errors.Wrap(err, "unable to read lead")
+	}
+
+	if !bytes.Equal(lead[:4], rpmLeadMagic) {
+		return errors.New("not an rpm package")
+	}
+
+	sigHeader, err := readRPMHeader(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to read signature header")
+	}
+
+	// The signature header is padded to a multiple of 8 bytes
+	padding := (8 - len(sigHeader.raw)%8) % 8
+	if _, err := r.Discard(padding); err != nil {
+		return errors.Wrap(err, "unable to read signature header")
+	}
+
+	header, err := readRPMHeader(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to read header")
+	}
+
+	payloadOffset := int64(rpmLeadSize + len(sigHeader.raw) + padding + len(header.raw))
+
+	payload := func() (io.Reader, error) {
+		if _, err := f.Seek(payloadOffset, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		return bufio.NewReader(f), nil
+	}
+
+	signed := false
+
+	for _, tag := range []uint32{rpmSigTagRSA, rpmSigTagDSA} {
+		if sig, ok := sigHeader.bin(tag); ok {
+			if err := VerifyDetached(keyring, bytes.NewReader(header.raw), sig); err != nil {
+				return errors.Wrap(err, "header signature")
+			}
+
+			signed = true
+		}
+	}
+
+	payloadSigned := false
+
+	for _, tag := range []uint32{rpmSigTagPGP, rpmSigTagGPG} {
+		if sig, ok := sigHeader.bin(tag); ok {
+			p, err := payload()
+			if err != nil {
+				return err
+			}
+
+			if err := VerifyDetached(keyring, io.MultiReader(bytes.NewReader(header.raw), p), sig); err != nil {
+				return errors.Wrap(err, "header and payload signature")
+			}
+
+			signed = true
+			payloadSigned = true
+		}
+	}
+
+	if !signed {
+		return errors.New("package is not signed")
+	}
+
+	if payloadSigned {
+		return nil
+	}
+
+	p, err := payload()
+	if err != nil {
+		return err
+	}
+
+	return verifyRPMPayload(header, p)
+}
+
+// verifyRPMPayload checks the compressed payload against the digest stored in
+// the header
+func verifyRPMPayload(header rpmHeader, payload io.Reader) error {
+	digest, ok := header.str(rpmTagPayloadDigest)
+	if !ok {
+		return errors.New("payload is not covered by a signature")
+	}
+
+	algo, ok := header.int32(rpmTagPayloadDigestAlgo)
+	if !ok {
+		algo = 8
+	}
+
+	hash, ok := rpmDigestAlgos[algo]
+	if !ok || !hash.Available() {
+		return errors.Errorf("unsupported payload digest algorithm %d", algo)
+	}
+
+	h := hash.New()
+	if _, err := io.Copy(h, payload); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		return errors.New("payload digest mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package gpg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"golang.org/x/crypto/openpgp"
+)
+
+type testRPMEntry struct {
+	typ   uint32
+	count uint32
+	data  []byte
+}
+
+func buildTestRPMHeader(entries map[uint32]testRPMEntry) []byte {
+	var tags []uint32
+	for tag := range entries {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	var index, store bytes.Buffer
+	for _, tag := range tags {
+		e := entries[tag]
+
+		if e.typ == rpmTypeInt32 {
+			for store.Len()%4 != 0 {
+				store.WriteByte(0)
+			}
+		}
+
+		binary.Write(&index, binary.BigEndian, []uint32{tag, e.typ, uint32(store.Len()), e.count})
+		store.Write(e.data)
+	}
+
+	var h bytes.Buffer
+	h.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&h, binary.BigEndian, []uint32{uint32(len(entries)), uint32(store.Len())})
+	h.Write(index.Bytes())
+	h.Write(store.Bytes())
+
+	return h.Bytes()
+}
+
+// writeTestRPM writes a minimal package with the given signature tags, each
+// signature is made by signer over the header or the header and payload
+func writeTestRPM(t *testing.T, signer *openpgp.Entity, sigTags []uint32, payloadDigest bool, payload []byte) string {
+	headerEntries := map[uint32]testRPMEntry{
+		1000: {rpmTypeString, 1, []byte("lagoon\x00")},
+	}
+
+	if payloadDigest {
+		sum := sha256.Sum256(payload)
+		headerEntries[rpmTagPayloadDigest] = testRPMEntry{rpmTypeStringArray, 1, []byte(hex.EncodeToString(sum[:]) + "\x00")}
+		headerEntries[rpmTagPayloadDigestAlgo] = testRPMEntry{rpmTypeInt32, 1, []byte{0, 0, 0, 8}}
+	}
+
+	header := buildTestRPMHeader(headerEntries)
+
+	sigEntries := map[uint32]testRPMEntry{}
+	for _, tag := range sigTags {
+		signed := header
+		if tag == rpmSigTagPGP || tag == rpmSigTagGPG {
+			signed = append(append([]byte{}, header...), payload...)
+		}
+
+		var sig bytes.Buffer
+		if err := openpgp.DetachSign(&sig, signer, bytes.NewReader(signed), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		sigEntries[tag] = testRPMEntry{rpmTypeBin, uint32(sig.Len()), sig.Bytes()}
+	}
+
+	sigHeader := buildTestRPMHeader(sigEntries)
+
+	var rpm bytes.Buffer
+	lead := make([]byte, rpmLeadSize)
+	copy(lead, rpmLeadMagic)
+	rpm.Write(lead)
+	rpm.Write(sigHeader)
+	rpm.Write(make([]byte, (8-len(sigHeader)%8)%8))
+	rpm.Write(header)
+	rpm.Write(payload)
+
+	path := filepath.Join(t.TempDir(), "lagoon-1.0-1.noarch.rpm")
+	if err := os.WriteFile(path, rpm.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestVerifyRPM(t *testing.T) {
+	signer := newTestEntity(t)
+	other := newTestEntity(t)
+	payload := []byte("compressed payload")
+
+	var tests = []struct {
+		name          string
+		signer        *openpgp.Entity
+		sigTags       []uint32
+		payloadDigest bool
+		tamper        bool
+		valid         bool
+	}{
+		{"header and payload signature", signer, []uint32{rpmSigTagRSA, rpmSigTagPGP}, false, false, true},
+		{"header signature with payload digest", signer, []uint32{rpmSigTagRSA}, true, false, true},
+		{"header signature without payload digest", signer, []uint32{rpmSigTagRSA}, false, false, false},
+		{"unsigned", signer, nil, true, false, false},
+		{"unknown signer", other, []uint32{rpmSigTagRSA}, true, false, false},
+		{"tampered payload with payload signature", signer, []uint32{rpmSigTagPGP}, false, true, false},
+		{"tampered payload with payload digest", signer, []uint32{rpmSigTagRSA}, true, true, false},
+	}
+	for _, test := range tests {
+		path := writeTestRPM(t, test.signer, test.sigTags, test.payloadDigest, payload)
+
+		if test.tamper {
+			data, _ := os.ReadFile(path)
+			os.WriteFile(path, bytes.Replace(data, payload, []byte("malicious payload!"), 1), 0644)
+		}
+
+		err := VerifyRPM(openpgp.EntityList{signer}, path)
+
+		if test.valid && err != nil {
+			t.Errorf("Test: %s should not result in error: %s", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Test: %s should result in error", test.name)
+		}
+	}
+
+	notRPM := filepath.Join(t.TempDir(), "not.rpm")
+	os.WriteFile(notRPM, []byte("not an rpm"), 0644)
+
+	assert.NotEqual(t, VerifyRPM(openpgp.EntityList{signer}, notRPM), nil)
+}
@@ -0,0 +1,199 @@
+package gpg
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ReadSigningKey reads the secret key with keyId, the key id or fingerprint in
+// hex, from a keyring file and decrypts it with passphrase when needed. Without
+// keyId the keyring must hold exactly one secret key.
+func ReadSigningKey(path string, keyId string, passphrase string) (*openpgp.Entity, error) {
+	keyring, err := ReadKeyring([]string{path})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*openpgp.Entity
+
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			continue
+		}
+
+		if keyId == "" || matchesKeyId(e, keyId) {
+			candidates = append(candidates, e)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && keyId != "":
+		return nil, errors.Errorf("secret key %s not found in %s", keyId, path)
+	case len(candidates) == 0:
+		return nil, errors.Errorf("no secret key found in %s", path)
+	case len(candidates) > 1:
+		return nil, errors.Errorf("multiple secret keys found in %s, a key id is required", path)
+	}
+
+	signer := candidates[0]
+
+	if err := decryptEntity(signer, passphrase); err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt secret key in %s", path)
+	}
+
+	return signer, nil
+}
+
+func matchesKeyId(e *openpgp.Entity, keyId string) bool {
+	keyId = strings.ToUpper(strings.TrimPrefix(keyId, "0x"))
+
+	keys := []*packet.PublicKey{e.PrimaryKey}
+	for _, s := range e.Subkeys {
+		keys = append(keys, s.PublicKey)
+	}
+
+	for _, k := range keys {
+		if strings.HasSuffix(fmt.Sprintf("%X", k.Fingerprint), keyId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decryptEntity(e *openpgp.Entity, passphrase string) error {
+	keys := []*packet.PrivateKey{e.PrivateKey}
+	for _, s := range e.Subkeys {
+		if s.PrivateKey != nil {
+			keys = append(keys, s.PrivateKey)
+		}
+	}
+
+	for _, k := range keys {
+		if !k.Encrypted {
+			continue
+		}
+
+		if passphrase == "" {
+			return errors.New("secret key is encrypted and no passphrase is configured")
+		}
+
+		if err := k.Decrypt([]byte(passphrase)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signingKey returns the newest valid signing subkey, or the primary key
+func signingKey(e *openpgp.Entity) *packet.PrivateKey {
+	now := time.Now()
+
+	var key *packet.PrivateKey
+	var created time.Time
+
+	for _, s := range e.Subkeys {
+		if s.PrivateKey == nil || !s.Sig.FlagsValid || !s.Sig.FlagSign || s.Sig.KeyExpired(now) {
+			continue
+		}
+
+		if key == nil || s.PublicKey.CreationTime.After(created) {
+			key = s.PrivateKey
+			created = s.PublicKey.CreationTime
+		}
+	}
+
+	if key == nil {
+		key = e.PrivateKey
+	}
+
+	return key
+}
+
+// SignDetached writes an armored detached signature of data to w, made with
+// the same key as ClearSign
+func SignDetached(w io.Writer, signer *openpgp.Entity, data io.Reader) error {
+	key := signingKey(signer)
+	if key == nil {
+		return errors.New("signing key has no private key")
+	}
+
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeBinary,
+		PubKeyAlgo:   key.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &key.KeyId,
+	}
+
+	h := sig.Hash.New()
+	if _, err := io.Copy(h, data); err != nil {
+		return err
+	}
+
+	if err := sig.Sign(h, key, nil); err != nil {
+		return err
+	}
+
+	aw, err := armor.Encode(w, openpgp.SignatureType, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := sig.Serialize(aw); err != nil {
+		return err
+	}
+
+	return aw.Close()
+}
+
+// ClearSign returns data as a clear signed message, such as a Debian InRelease
+func ClearSign(signer *openpgp.Entity, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := clearsign.Encode(&buf, signingKey(signer), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ExportPublicKey writes the armored public key of e to w
+func ExportPublicKey(w io.Writer, e *openpgp.Entity) error {
+	aw, err := armor.Encode(w, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := e.Serialize(aw); err != nil {
+		return err
+	}
+
+	if err := aw.Close(); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+
+	return err
+}
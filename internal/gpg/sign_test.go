@@ -0,0 +1,169 @@
+package gpg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func writeTestSecretKeyring(t *testing.T, entities ...*openpgp.Entity) string {
+	var buf bytes.Buffer
+
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entities {
+		if err := e.SerializePrivate(w, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	path := filepath.Join(t.TempDir(), "secring.asc")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadSigningKey(t *testing.T) {
+	e1 := newTestEntity(t)
+	e2 := newTestEntity(t)
+
+	single := writeTestSecretKeyring(t, e1)
+	multiple := writeTestSecretKeyring(t, e1, e2)
+	public := writeTestKeyring(t, true, e1)
+
+	var tests = []struct {
+		path     string
+		keyId    string
+		expected uint64
+		valid    bool
+	}{
+		{single, "", e1.PrimaryKey.KeyId, true},
+		{single, e1.PrimaryKey.KeyIdString(), e1.PrimaryKey.KeyId, true},
+		{multiple, "0x" + e2.PrimaryKey.KeyIdString(), e2.PrimaryKey.KeyId, true},
+		{multiple, "", 0, false},
+		{single, e2.PrimaryKey.KeyIdString(), 0, false},
+		{public, "", 0, false},
+	}
+	for i, test := range tests {
+		signer, err := ReadSigningKey(test.path, test.keyId, "")
+
+		if test.valid {
+			if err != nil {
+				t.Errorf("Test: %d should not result in error: %s", i, err)
+			} else {
+				assert.Equal(t, signer.PrimaryKey.KeyId, test.expected)
+			}
+		} else if err == nil {
+			t.Errorf("Test: %d should result in error", i)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	signer := newTestEntity(t)
+	data := []byte("Origin: Lagoon\nSuite: stable\n")
+
+	var key bytes.Buffer
+	assert.Equal(t, ExportPublicKey(&key, signer), nil)
+
+	path := filepath.Join(t.TempDir(), "signing-key.asc")
+	os.WriteFile(path, key.Bytes(), 0644)
+
+	keyring, err := ReadKeyring([]string{path})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keyring[0].PrivateKey == nil, true)
+
+	var sig bytes.Buffer
+	assert.Equal(t, SignDetached(&sig, signer, bytes.NewReader(data)), nil)
+	assert.Equal(t, VerifyDetached(keyring, bytes.NewReader(data), sig.Bytes()), nil)
+
+	signed, err := ClearSign(signer, data)
+	assert.Equal(t, err, nil)
+
+	plaintext, err := VerifyClearSigned(keyring, signed)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, plaintext, data)
+}
+
+// addSigningSubkey adds a signing subkey to e, as keys of which the primary key
+// is kept offline have
+func addSigningSubkey(t *testing.T, e *openpgp.Entity) *packet.PrivateKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Now()
+
+	key := packet.NewRSAPrivateKey(created, rsaKey)
+	key.IsSubkey = true
+	key.PublicKey.IsSubkey = true
+
+	sig := &packet.Signature{
+		CreationTime: created,
+		SigType:      packet.SigTypeSubkeyBinding,
+		PubKeyAlgo:   packet.PubKeyAlgoRSA,
+		Hash:         crypto.SHA256,
+		FlagsValid:   true,
+		FlagSign:     true,
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+
+	if err := sig.SignKey(&key.PublicKey, e.PrivateKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Subkeys = append(e.Subkeys, openpgp.Subkey{PublicKey: &key.PublicKey, PrivateKey: key, Sig: sig})
+
+	return key
+}
+
+func TestSignWithSubkey(t *testing.T) {
+	signer := newTestEntity(t)
+	subkey := addSigningSubkey(t, signer)
+	data := []byte("<repomd/>")
+
+	// The keyring is not read back, this version of openpgp can not serialize
+	// the cross-signature a signing subkey needs
+	keyring := openpgp.EntityList{signer}
+
+	var sig bytes.Buffer
+	assert.Equal(t, SignDetached(&sig, signer, bytes.NewReader(data)), nil)
+	assert.Equal(t, VerifyDetached(keyring, bytes.NewReader(data), sig.Bytes()), nil)
+
+	// Both signatures are made with the subkey, not the primary key
+	block, err := armor.Decode(bytes.NewReader(sig.Bytes()))
+	assert.Equal(t, err, nil)
+
+	p, err := packet.Read(block.Body)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *p.(*packet.Signature).IssuerKeyId, subkey.KeyId)
+
+	signed, err := ClearSign(signer, data)
+	assert.Equal(t, err, nil)
+
+	_, err = VerifyClearSigned(keyring, signed)
+	assert.Equal(t, err, nil)
+
+	clearSigned, _ := clearsign.Decode(signed)
+	p, err = packet.Read(clearSigned.ArmoredSignature.Body)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *p.(*packet.Signature).IssuerKeyId, subkey.KeyId)
+}
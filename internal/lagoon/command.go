@@ -0,0 +1,166 @@
+package lagoon
+
+import (
+	"flag"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/klaasjand/lagoon/internal/config"
+	"github.com/klaasjand/lagoon/internal/repository"
+	"github.com/pkg/errors"
+)
+
+// Commands act on the snapshots of a repository instead of running the
+// daemon, e.g. lagoon protect -reason "pinned by production" <repo> <snapshot>
+var commands = map[string]func(args []string) error{
+	"protect":   protectCommand,
+	"unprotect": unprotectCommand,
+	"promote":   promoteCommand,
+	"rollback":  rollbackCommand,
+	"clear":     clearCommand,
+}
+
+func runCommand(args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return errors.Errorf("unknown command %s", args[0])
+	}
+
+	return command(args[1:])
+}
+
+// findRepo returns the configured repository with id
+func findRepo(id string) (*repository.Repo, error) {
+	for _, rc := range config.RepoConfigs {
+		if rc.Id == id {
+			return repository.NewRepo(rc, config.GlobalConfig, &sync.WaitGroup{})
+		}
+	}
+
+	return nil, errors.Errorf("repository '%s' not found", id)
+}
+
+// parseSnapshotArgs parses the flags of a command followed by a repository id
+// and a snapshot
+func parseSnapshotArgs(flags *flag.FlagSet, args []string) (*repository.Repo, string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+
+	if flags.NArg() != 2 {
+		return nil, "", errors.Errorf("usage: lagoon %s [options] <repo> <snapshot>", flags.Name())
+	}
+
+	m, err := findRepo(flags.Arg(0))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return m, flags.Arg(1), nil
+}
+
+func protectCommand(args []string) error {
+	flags := flag.NewFlagSet("protect", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the snapshot is protected")
+	expires := flags.String("expires", "", "protect the snapshot for a period only, e.g. 90d, 2w or 36h")
+
+	m, snapshot, err := parseSnapshotArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	var duration time.Duration
+	if *expires != "" {
+		if duration, err = repository.ParseDuration(*expires); err != nil {
+			return errors.Wrap(err, "expires")
+		}
+	}
+
+	return m.Protect(snapshot, *reason, duration)
+}
+
+func unprotectCommand(args []string) error {
+	m, snapshot, err := parseSnapshotArgs(flag.NewFlagSet("unprotect", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+
+	return m.Unprotect(snapshot)
+}
+
+// currentUser returns the operator running a command, the user who invoked
+// sudo rather than root
+func currentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return "unknown"
+}
+
+func promoteCommand(args []string) error {
+	flags := flag.NewFlagSet("promote", flag.ContinueOnError)
+	by := flags.String("by", currentUser(), "who promotes the snapshot")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 3 {
+		return errors.New("usage: lagoon promote [options] <repo> <channel> <snapshot>")
+	}
+
+	m, err := findRepo(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return m.Promote(flags.Arg(1), flags.Arg(2), *by)
+}
+
+func rollbackCommand(args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	to := flags.String("to", "", "snapshot or channel to roll back to, defaults to the snapshot before the current one")
+	reason := flags.String("reason", "", "why the snapshot is rolled back")
+	by := flags.String("by", currentUser(), "who rolls back the snapshot")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("usage: lagoon rollback [options] <repo> <latest|channel>")
+	}
+
+	m, err := findRepo(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return m.Rollback(flags.Arg(1), *to, *reason, *by)
+}
+
+func clearCommand(args []string) error {
+	flags := flag.NewFlagSet("clear", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("usage: lagoon clear <repo> <latest|channel>")
+	}
+
+	m, err := findRepo(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return m.ClearRollback(flags.Arg(1))
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/klaasjand/lagoon/internal/config"
 	"github.com/klaasjand/lagoon/internal/repository"
+	"github.com/klaasjand/lagoon/internal/secret"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
@@ -32,6 +33,14 @@ func Run() {
 		log.Fatal().Stack().Err(err).Msg("Unable to load configuration")
 	}
 
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(args); err != nil {
+			log.Fatal().Err(err).Msg("Command failed")
+		}
+
+		return
+	}
+
 	var wg sync.WaitGroup
 
 	names := make(map[string]bool)
@@ -40,7 +49,7 @@ func Run() {
 		if _, value := names[rc.Id]; !value {
 			names[rc.Id] = true
 
-			if m, err := repository.NewRepo(rc, &wg); err == nil {
+			if m, err := repository.NewRepo(rc, config.GlobalConfig, &wg); err == nil {
 				repos = append(repos, m)
 			} else {
 				log.Warn().Err(err).Msg("Cannot add repo")
@@ -50,6 +59,10 @@ func Run() {
 		}
 	}
 
+	if err := repository.RemoveStaleState(config.RepoConfigs); err != nil {
+		log.Warn().Err(err).Msg("Unable to remove state of repos which are no longer configured")
+	}
+
 	log.Info().Msg("Running preflight checks")
 	for _, m := range repos {
 		if err := m.PreReqs(); err != nil {
@@ -120,8 +133,12 @@ func initLogger() {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
+	// Secrets are redacted from all log output
+	out := secret.NewWriter(os.Stderr)
+	log.Logger = log.Output(out)
+
 	// Use -h to enable human readable logging
 	if *human {
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "2006-01-02T15:04:05.999Z07:00"}).With().Caller().Logger()
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: out, TimeFormat: "2006-01-02T15:04:05.999Z07:00"}).With().Caller().Logger()
 	}
 }
@@ -0,0 +1,64 @@
+package remote
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type bandwidthLimitKey struct{}
+
+// WithBandwidthLimit returns a context which limits the bandwidth of a sync to
+// the number of bytes per second returned by limit, 0 means unlimited. The
+// limit is asked for at the start of every rsync or reposync run and every
+// download, so a long sync follows a schedule.
+func WithBandwidthLimit(ctx context.Context, limit func() int64) context.Context {
+	return context.WithValue(ctx, bandwidthLimitKey{}, limit)
+}
+
+func bandwidthLimit(ctx context.Context) int64 {
+	if limit, ok := ctx.Value(bandwidthLimitKey{}).(func() int64); ok {
+		return limit()
+	}
+
+	return 0
+}
+
+// rateLimitedReader limits reads from r to the bandwidth limit found in ctx
+type rateLimitedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit int64
+	start time.Time
+	read  int64
+}
+
+func newRateLimitedReader(ctx context.Context, r io.Reader) io.Reader {
+	limit := bandwidthLimit(ctx)
+	if limit <= 0 {
+		return r
+	}
+
+	return &rateLimitedReader{ctx: ctx, r: r, limit: limit, start: time.Now()}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// Never read more than a second worth of data at once
+	if int64(len(p)) > l.limit {
+		p = p[:l.limit]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	expected := time.Duration(float64(l.read) / float64(l.limit) * float64(time.Second))
+	if wait := expected - time.Since(l.start); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-l.ctx.Done():
+			return n, l.ctx.Err()
+		}
+	}
+
+	return n, err
+}
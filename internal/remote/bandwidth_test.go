@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestBandwidthLimit(t *testing.T) {
+	assert.Equal(t, bandwidthLimit(context.Background()), int64(0))
+
+	limit := int64(1024)
+	ctx := WithBandwidthLimit(context.Background(), func() int64 { return limit })
+	assert.Equal(t, bandwidthLimit(ctx), int64(1024))
+
+	// The limit is evaluated on every use
+	limit = 0
+	assert.Equal(t, bandwidthLimit(ctx), int64(0))
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	ctx := WithBandwidthLimit(context.Background(), func() int64 { return 10 * 1024 })
+	data := make([]byte, 5*1024)
+
+	start := time.Now()
+
+	n, err := io.Copy(io.Discard, newRateLimitedReader(ctx, bytes.NewReader(data)))
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, int64(len(data)))
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Reading 5KB at 10KB/s should take about 500ms, took %v", elapsed)
+	}
+}
@@ -0,0 +1,96 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Number of trailing stderr lines included in the error of a failed command
+const maxStderrLines = 20
+
+// Maximum length of a single line of command output
+const maxOutputLineSize = 1024 * 1024
+
+// runCommand runs cmd and streams its output line by line to the logger found
+// in ctx, stdout at debug and stderr at warn level. Each stdout line is also
+// passed to onStdout when it is not nil. When the command fails the last lines
+// written to stderr are included in the returned error.
+func runCommand(ctx context.Context, cmd *exec.Cmd, onStdout func(line string)) error {
+	cmdLog := zerolog.Ctx(ctx).With().Str("command", filepath.Base(cmd.Path)).Logger()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var tail []string
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		scanLines(stdout, func(line string) {
+			cmdLog.Debug().Str("stream", "stdout").Msg(line)
+
+			if onStdout != nil {
+				onStdout(line)
+			}
+		})
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		scanLines(stderr, func(line string) {
+			cmdLog.Warn().Str("stream", "stderr").Msg(line)
+
+			if tail = append(tail, line); len(tail) > maxStderrLines {
+				tail = tail[1:]
+			}
+		})
+	}()
+
+	// All output must be read before waiting for the command to exit
+	wg.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		if len(tail) > 0 {
+			return errors.Wrapf(err, "%s failed: %s", filepath.Base(cmd.Path), strings.Join(tail, "; "))
+		}
+
+		return errors.Wrapf(err, "%s failed", filepath.Base(cmd.Path))
+	}
+
+	return nil
+}
+
+func scanLines(r io.Reader, fn func(line string)) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxOutputLineSize)
+
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+
+	// Drain the remainder, e.g. after a too long line, so the command does not block
+	io.Copy(io.Discard, r)
+}
@@ -0,0 +1,54 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestRunCommandStdout(t *testing.T) {
+	var lines []string
+
+	cmd := exec.Command("sh", "-c", "echo one; echo two")
+	err := runCommand(context.Background(), cmd, func(line string) {
+		lines = append(lines, line)
+	})
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, lines, []string{"one", "two"})
+}
+
+func TestRunCommandStderrTail(t *testing.T) {
+	script := fmt.Sprintf("for i in $(seq 1 %d); do echo line$i >&2; done; exit 23", maxStderrLines+5)
+
+	err := runCommand(context.Background(), exec.Command("sh", "-c", script), nil)
+	if err == nil {
+		t.Fatal("Failing command should result in error")
+	}
+
+	if strings.Contains(err.Error(), "line5;") || !strings.Contains(err.Error(), fmt.Sprintf("line%d", maxStderrLines+5)) {
+		t.Errorf("Error should only contain the last %d stderr lines: %v", maxStderrLines, err)
+	}
+
+	if !strings.Contains(err.Error(), "exit status 23") {
+		t.Errorf("Error should contain the exit status: %v", err)
+	}
+}
+
+func TestRunCommandCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := runCommand(ctx, exec.CommandContext(ctx, "sleep", "10"), nil)
+
+	assert.NotEqual(t, err, nil)
+	if time.Since(start) > 5*time.Second {
+		t.Error("Cancelling the context should stop the command")
+	}
+}
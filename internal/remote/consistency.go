@@ -0,0 +1,499 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// Hashes used for repository metadata checksums
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Checksum types used in repomd.xml and Debian Release files
+var checksumHashes = map[string]crypto.Hash{
+	"md5":    crypto.MD5,
+	"md5sum": crypto.MD5,
+	"sha":    crypto.SHA1,
+	"sha1":   crypto.SHA1,
+	"sha224": crypto.SHA224,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// Release checksum sections, strongest first
+var releaseChecksumSections = []string{"SHA512", "SHA256", "SHA1", "MD5Sum"}
+
+// checkRepoTree checks that every file referenced by the repomd.xml and
+// Release files below root exists with the right size and checksum, which is
+// not the case while the upstream is being updated. Files which the filter
+// excludes from the sync are not checked.
+func checkRepoTree(ctx context.Context, root string, filter RsyncFilter) error {
+	excluded := func(path string) bool {
+		rel, err := filepath.Rel(root, path)
+
+		return err == nil && filter.excludes(rel)
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		dir := filepath.Dir(path)
+
+		switch {
+		case d.Name() == "repomd.xml" && filepath.Base(dir) == "repodata":
+			return checkRepomd(ctx, filepath.Dir(dir), excluded)
+		case d.Name() == "Release" && filepath.Base(filepath.Dir(dir)) == "dists":
+			return checkRelease(dir)
+		case d.Name() == "InRelease" && filepath.Base(filepath.Dir(dir)) == "dists":
+			// A suite with both is checked once through its Release file
+			if _, err := os.Stat(filepath.Join(dir, "Release")); os.IsNotExist(err) {
+				return checkRelease(dir)
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkFile checks the size and checksum of a file, a negative size or an
+// empty checksum is not checked
+func checkFile(path string, checksumType string, checksum string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 && info.Size() != size {
+		return errors.Errorf("%s has size %d, expected %d", path, info.Size(), size)
+	}
+
+	if checksum == "" {
+		return nil
+	}
+
+	hash, ok := checksumHashes[strings.ToLower(checksumType)]
+	if !ok {
+		return errors.Errorf("unsupported checksum type %s for %s", checksumType, path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := hash.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != strings.ToLower(strings.TrimSpace(checksum)) {
+		return errors.Errorf("checksum mismatch for %s", path)
+	}
+
+	return nil
+}
+
+// checkRepomd checks the metadata listed in the repomd.xml of the repository
+// at repoPath and the packages listed in its primary metadata, skipping the
+// files for which excluded, when set, returns true
+func checkRepomd(ctx context.Context, repoPath string, excluded func(path string) bool) error {
+	data, err := os.ReadFile(filepath.Join(repoPath, "repodata", "repomd.xml"))
+	if err != nil {
+		return err
+	}
+
+	var md repomd
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return errors.Wrapf(err, "unable to decode repomd.xml of %s", repoPath)
+	}
+
+	for _, d := range md.Data {
+		path := filepath.Join(repoPath, filepath.FromSlash(d.Location.Href))
+		if excluded != nil && excluded(path) {
+			continue
+		}
+
+		if err := checkFile(path, d.Checksum.Type, d.Checksum.Value, -1); err != nil {
+			return errors.Wrapf(err, "%s metadata", d.Type)
+		}
+
+		if d.Type == "primary" {
+			if err := checkPackages(ctx, repoPath, path, excluded); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type primaryPackage struct {
+	Location struct {
+		Href string `xml:"href,attr"`
+		Base string `xml:"base,attr"`
+	} `xml:"location"`
+	Size struct {
+		Package int64 `xml:"package,attr"`
+	} `xml:"size"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+}
+
+// checkPackages checks that the packages listed in the primary metadata exist
+// with the right size and checksum
+func checkPackages(ctx context.Context, repoPath string, primaryPath string, excluded func(path string) bool) error {
+	return primaryPackages(ctx, primaryPath, func(pkg primaryPackage) error {
+		// Packages hosted elsewhere are not part of the tree
+		if pkg.Location.Base != "" {
+			return nil
+		}
+
+		path := filepath.Join(repoPath, filepath.FromSlash(pkg.Location.Href))
+		if excluded != nil && excluded(path) {
+			return nil
+		}
+
+		return errors.Wrap(checkFile(path, pkg.Checksum.Type, pkg.Checksum.Value, pkg.Size.Package), "package")
+	})
+}
+
+// primaryPackages calls fn for every package listed in the primary metadata
+func primaryPackages(ctx context.Context, primaryPath string, fn func(pkg primaryPackage) error) error {
+	data, err := readMetadata(ctx, primaryPath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", primaryPath)
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "unable to decode %s", primaryPath)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		var pkg primaryPackage
+		if err := decoder.DecodeElement(&pkg, &start); err != nil {
+			return errors.Wrapf(err, "unable to decode %s", primaryPath)
+		}
+
+		if err := fn(pkg); err != nil {
+			return err
+		}
+	}
+}
+
+// CheckSignedRepomd checks the repository at repoPath against the checksums
+// in its repomd.xml, so a valid signature of the repomd.xml covers the whole
+// repository. Every metadata file must match the repomd.xml and every package
+// below repoPath must be listed in the primary metadata with its size and
+// checksum. Packages are matched by file name, reposync does not always keep
+// the folder structure of the upstream. Packages for which kept, when set,
+// returns true are not checked, listed packages which are not in the tree are
+// skipped.
+func CheckSignedRepomd(ctx context.Context, repoPath string, kept func(path string) bool) error {
+	data, err := os.ReadFile(filepath.Join(repoPath, "repodata", "repomd.xml"))
+	if err != nil {
+		return err
+	}
+
+	var md repomd
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return errors.Wrapf(err, "unable to decode repomd.xml of %s", repoPath)
+	}
+
+	listed := map[string]primaryPackage{}
+
+	for _, d := range md.Data {
+		path := filepath.Join(repoPath, filepath.FromSlash(d.Location.Href))
+
+		// Without a checksum the signature does not cover the file
+		if err := checkFile(path, d.Checksum.Type, d.Checksum.Value, -1); err != nil || d.Checksum.Value == "" {
+			if err == nil {
+				err = errors.Errorf("no checksum for %s", path)
+			}
+
+			return errors.Wrapf(err, "%s metadata", d.Type)
+		}
+
+		if d.Type == "primary" {
+			err := primaryPackages(ctx, path, func(pkg primaryPackage) error {
+				listed[filepath.Base(filepath.FromSlash(pkg.Location.Href))] = pkg
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			// A nested repository is checked against its own repomd.xml
+			if _, err := os.Stat(filepath.Join(path, "repodata", "repomd.xml")); err == nil && path != repoPath {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(d.Name(), ".rpm") {
+			return nil
+		}
+
+		if kept != nil && kept(path) {
+			return nil
+		}
+
+		pkg, ok := listed[d.Name()]
+		if !ok {
+			return errors.Errorf("package %s is not listed in the primary metadata", path)
+		}
+
+		if pkg.Checksum.Value == "" {
+			return errors.Errorf("no checksum for package %s", path)
+		}
+
+		return errors.Wrap(checkFile(path, pkg.Checksum.Type, pkg.Checksum.Value, pkg.Size.Package), "package")
+	})
+}
+
+// checkRelease checks the index files listed in the Release, or InRelease,
+// file of a Debian suite
+func checkRelease(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "Release"))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(filepath.Join(dir, "InRelease"))
+		if err != nil {
+			return err
+		}
+
+		block, _ := clearsign.Decode(data)
+		if block == nil {
+			return errors.Errorf("no clear signed message found in %s", filepath.Join(dir, "InRelease"))
+		}
+
+		data = block.Plaintext
+	} else if err != nil {
+		return err
+	}
+
+	_, err = checkReleaseIndexes(dir, data)
+
+	return err
+}
+
+// checkReleaseIndexes checks the index files listed in the content of the
+// Release file of the Debian suite at dir and returns the indexes which exist.
+// Indexes are often listed in several compressions of which only some exist,
+// missing files are skipped.
+func checkReleaseIndexes(dir string, release []byte) ([]string, error) {
+	sections := parseReleaseChecksums(release)
+
+	for _, section := range releaseChecksumSections {
+		entries, ok := sections[section]
+		if !ok {
+			continue
+		}
+
+		var indexes []string
+
+		for _, e := range entries {
+			path := filepath.Join(dir, filepath.FromSlash(e.path))
+
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
+
+			if err := checkFile(path, section, e.checksum, e.size); err != nil {
+				return nil, err
+			}
+
+			indexes = append(indexes, path)
+		}
+
+		return indexes, nil
+	}
+
+	return nil, errors.Errorf("no checksums found in Release of %s", dir)
+}
+
+// Package checksum fields of Debian Packages indexes, strongest first
+var packagesChecksumFields = []string{"SHA512", "SHA256", "SHA1", "MD5sum"}
+
+// packagesIndexes matches the Packages indexes of a suite in any compression
+var packagesIndexes = regexp.MustCompile(`^Packages(\.(gz|bz2|xz))?$`)
+
+type debPackage struct {
+	checksumType string
+	checksum     string
+	size         int64
+}
+
+// readPackagesIndex adds the packages listed in a Debian Packages index to
+// listed by path, paths in the index are relative to the archive root
+func readPackagesIndex(ctx context.Context, root string, path string, listed map[string]debPackage) error {
+	data, err := readMetadata(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", path)
+	}
+
+	for _, stanza := range strings.Split(string(data), "\n\n") {
+		fields := map[string]string{}
+
+		for _, line := range strings.Split(stanza, "\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, " ") {
+				fields[name] = strings.TrimSpace(value)
+			}
+		}
+
+		if fields["Filename"] == "" {
+			continue
+		}
+
+		pkg := debPackage{size: -1}
+
+		if size, err := strconv.ParseInt(fields["Size"], 10, 64); err == nil {
+			pkg.size = size
+		}
+
+		for _, field := range packagesChecksumFields {
+			if fields[field] != "" {
+				pkg.checksumType, pkg.checksum = field, fields[field]
+
+				break
+			}
+		}
+
+		listed[filepath.Join(root, filepath.FromSlash(fields["Filename"]))] = pkg
+	}
+
+	return nil
+}
+
+// CheckSignedReleases checks the Debian suites below root against the content
+// of their verified Release files by suite folder, so valid signatures of the
+// Release files cover the whole archive. Every index which exists must match
+// the Release and every package below root must be listed in a Packages index
+// with its size and checksum. Packages for which kept, when set, returns true
+// are not checked.
+func CheckSignedReleases(ctx context.Context, root string, releases map[string][]byte, kept func(path string) bool) error {
+	listed := map[string]debPackage{}
+
+	for dir, release := range releases {
+		indexes, err := checkReleaseIndexes(dir, release)
+		if err != nil {
+			return err
+		}
+
+		// The archive root holds the dists and pool folders
+		archive := filepath.Dir(filepath.Dir(dir))
+
+		for _, index := range indexes {
+			if packagesIndexes.MatchString(filepath.Base(index)) {
+				if err := readPackagesIndex(ctx, archive, index, listed); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if !strings.HasSuffix(d.Name(), ".deb") && !strings.HasSuffix(d.Name(), ".udeb") {
+			return nil
+		}
+
+		if kept != nil && kept(path) {
+			return nil
+		}
+
+		pkg, ok := listed[path]
+		if !ok {
+			return errors.Errorf("package %s is not listed in a Packages index", path)
+		}
+
+		if pkg.checksum == "" {
+			return errors.Errorf("no checksum for package %s", path)
+		}
+
+		return errors.Wrap(checkFile(path, pkg.checksumType, pkg.checksum, pkg.size), "package")
+	})
+}
+
+type releaseEntry struct {
+	checksum string
+	size     int64
+	path     string
+}
+
+// parseReleaseChecksums returns the entries of the checksum sections of a
+// Release file by section name
+func parseReleaseChecksums(data []byte) map[string][]releaseEntry {
+	sections := map[string][]releaseEntry{}
+	section := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !strings.HasPrefix(line, " ") {
+			section = ""
+			if field, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(value) == "" {
+				section = field
+			}
+
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if section == "" || len(fields) != 3 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		sections[section] = append(sections[section], releaseEntry{checksum: fields[0], size: size, path: fields[2]})
+	}
+
+	return sections
+}
@@ -0,0 +1,122 @@
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func writeTestRepo(t *testing.T, root string) {
+	primary := `<metadata packages="1">
+  <package type="rpm"><name>bash</name><checksum type="sha256">%s</checksum><size package="4"/><location href="Packages/bash-5.1-1.x86_64.rpm"/></package>
+  <package type="rpm"><name>remote</name><size package="4"/><location xml:base="https://example.com/" href="remote-1.0-1.x86_64.rpm"/></package>
+</metadata>`
+	primary = fmt.Sprintf(primary, sha256Hex("bash"))
+
+	writeTestFiles(t, root, map[string]string{
+		"repodata/repomd.xml":            fmt.Sprintf(`<repomd><data type="primary"><checksum type="sha256">%s</checksum><location href="repodata/primary.xml"/></data></repomd>`, sha256Hex(primary)),
+		"repodata/primary.xml":           primary,
+		"Packages/bash-5.1-1.x86_64.rpm": "bash",
+	})
+}
+
+func TestCheckRepoTree(t *testing.T) {
+	root := t.TempDir()
+	writeTestRepo(t, root)
+
+	assert.Equal(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+
+	// A package which is still being transferred
+	assert.Equal(t, os.WriteFile(filepath.Join(root, "Packages/bash-5.1-1.x86_64.rpm"), []byte("ba"), 0644), nil)
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+
+	// A package of the right size with other content
+	assert.Equal(t, os.WriteFile(filepath.Join(root, "Packages/bash-5.1-1.x86_64.rpm"), []byte("bask"), 0644), nil)
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+
+	// A package which has not arrived yet
+	assert.Equal(t, os.Remove(filepath.Join(root, "Packages/bash-5.1-1.x86_64.rpm")), nil)
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+
+	// Metadata which does not match the repomd.xml
+	root = t.TempDir()
+	writeTestRepo(t, root)
+	assert.Equal(t, os.WriteFile(filepath.Join(root, "repodata/primary.xml"), []byte("<metadata/>"), 0644), nil)
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+}
+
+func TestCheckRepoTreeFilter(t *testing.T) {
+	primary := `<metadata packages="2">
+  <package type="rpm"><name>bash</name><size package="4"/><location href="Packages/bash-5.1-1.x86_64.rpm"/></package>
+  <package type="rpm"><name>bash-debuginfo</name><size package="4"/><location href="debug/bash-debuginfo-5.1-1.x86_64.rpm"/></package>
+</metadata>`
+
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"7/os/repodata/repomd.xml":            fmt.Sprintf(`<repomd><data type="primary"><checksum type="sha256">%s</checksum><location href="repodata/primary.xml"/></data></repomd>`, sha256Hex(primary)),
+		"7/os/repodata/primary.xml":           primary,
+		"7/os/Packages/bash-5.1-1.x86_64.rpm": "bash",
+	})
+
+	// A two phase sync which excludes the debug packages
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+	assert.Equal(t, checkRepoTree(context.Background(), root, RsyncFilter{Exclude: []string{"debug/"}}), nil)
+	assert.Equal(t, checkRepoTree(context.Background(), root, RsyncFilter{Filters: []string{"- *-debuginfo-*.rpm"}}), nil)
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{Exclude: []string{"/debug/"}}), nil)
+}
+
+func TestCheckRelease(t *testing.T) {
+	packages := "Package: bash\n"
+
+	release := fmt.Sprintf(`Origin: Debian
+Suite: stable
+MD5Sum:
+ 00000000000000000000000000000000 %d main/binary-amd64/Packages
+SHA256:
+ %s %d main/binary-amd64/Packages
+ %s 10 main/binary-amd64/Packages.xz
+`, len(packages), sha256Hex(packages), len(packages), sha256Hex("missing"))
+
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"dists/stable/Release":                    release,
+		"dists/stable/main/binary-amd64/Packages": packages,
+	})
+
+	// The weaker MD5 checksums are ignored and missing compressions skipped
+	assert.Equal(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+
+	writeTestFiles(t, root, map[string]string{
+		"dists/stable/main/binary-amd64/Packages": "Package: zsh\n",
+	})
+	assert.NotEqual(t, checkRepoTree(context.Background(), root, RsyncFilter{}), nil)
+}
+
+func TestParseReleaseChecksums(t *testing.T) {
+	sections := parseReleaseChecksums([]byte(`Origin: Debian
+Architectures: amd64 arm64
+SHA256:
+ abc 12 main/binary-amd64/Packages
+ def 34 main/binary-arm64/Packages
+Acquire-By-Hash: yes
+`))
+
+	assert.Equal(t, sections, map[string][]releaseEntry{
+		"SHA256": {
+			{checksum: "abc", size: 12, path: "main/binary-amd64/Packages"},
+			{checksum: "def", size: 34, path: "main/binary-arm64/Packages"},
+		},
+	})
+}
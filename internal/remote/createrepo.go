@@ -0,0 +1,140 @@
+package remote
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+)
+
+// createRepo regenerates the repodata of the repository at repoPath. The
+// groupdata, errata and modular metadata of the existing repodata are added
+// to the generated repodata, temporary files are written to tmpPath.
+func createRepo(ctx context.Context, repoPath string, tmpPath string) error {
+	var cmd *exec.Cmd
+
+	// Additional metadata must be read before createrepo replaces the repodata
+	mdDir, err := os.MkdirTemp(tmpPath, "metadata-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(mdDir)
+
+	compsPath, err := prepareComps(ctx, repoPath, mdDir)
+	if err != nil {
+		return err
+	}
+
+	if compsPath != "" {
+		zerolog.Ctx(ctx).Debug().Msg("Groupdata found")
+
+		cmd = exec.CommandContext(ctx, "createrepo", "--update", "-p", "--workers", "2", "-g", compsPath, repoPath)
+	} else {
+		zerolog.Ctx(ctx).Debug().Msg("Groupdata not found")
+
+		cmd = exec.CommandContext(ctx, "createrepo", "--update", "-p", "--workers", "2", repoPath)
+	}
+
+	mdFiles, err := prepareMetadata(ctx, repoPath, mdDir)
+	if err != nil {
+		return err
+	}
+
+	if err := runCommand(ctx, cmd, nil); err != nil {
+		return err
+	}
+
+	// The upstream signature does not match the generated repomd.xml
+	if err := os.Remove(filepath.Join(repoPath, "repodata", "repomd.xml.asc")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for mdType, mdFile := range mdFiles {
+		if err := addMetadata(ctx, repoPath, mdFile); err != nil {
+			return err
+		}
+
+		if err := verifyMetadata(repoPath, mdType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareComps returns the path of the groupdata of the repository, or an
+// empty string without groupdata. yum-utils stores it as comps.xml next to
+// the packages, dnf only within the repodata, which is uncompressed to dir.
+func prepareComps(ctx context.Context, repoPath string, dir string) (string, error) {
+	compsPath := filepath.Join(repoPath, "comps.xml")
+	if _, err := os.Stat(compsPath); err == nil {
+		return compsPath, nil
+	}
+
+	comps, err := readUpstreamMetadata(ctx, repoPath, "group")
+	if err != nil || comps == nil {
+		return "", err
+	}
+
+	compsPath = filepath.Join(dir, "comps.xml")
+	if err := os.WriteFile(compsPath, comps, 0644); err != nil {
+		return "", err
+	}
+
+	return compsPath, nil
+}
+
+// prepareMetadata writes the upstream metadata which createrepo does not
+// generate to dir and returns the written files by metadata type. Errata are
+// trimmed to the packages present in the repository, modular metadata is
+// needed as is for module streams to work on EL8 and later.
+func prepareMetadata(ctx context.Context, repoPath string, dir string) (map[string]string, error) {
+	mdFiles := map[string]string{}
+
+	updateInfo, err := readUpstreamMetadata(ctx, repoPath, "updateinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	if updateInfo != nil {
+		present, err := rpmFiles(repoPath)
+		if err != nil {
+			return nil, err
+		}
+
+		trimmed, dropped, err := trimUpdateInfo(updateInfo, present)
+		if err != nil {
+			return nil, err
+		}
+
+		zerolog.Ctx(ctx).Debug().Int("dropped", dropped).Msg("Errata found, dropped advisories without packages in snapshot")
+
+		// modifyrepo derives the metadata type from the file name
+		mdFiles["updateinfo"] = filepath.Join(dir, "updateinfo.xml")
+		if err := os.WriteFile(mdFiles["updateinfo"], trimmed, 0644); err != nil {
+			return nil, err
+		}
+	} else {
+		zerolog.Ctx(ctx).Debug().Msg("Errata not found")
+	}
+
+	modules, err := readUpstreamMetadata(ctx, repoPath, "modules")
+	if err != nil {
+		return nil, err
+	}
+
+	if modules != nil {
+		zerolog.Ctx(ctx).Debug().Msg("Modular metadata found")
+
+		mdFiles["modules"] = filepath.Join(dir, "modules.yaml")
+		if err := os.WriteFile(mdFiles["modules"], modules, 0644); err != nil {
+			return nil, err
+		}
+	} else {
+		zerolog.Ctx(ctx).Debug().Msg("Modular metadata not found")
+	}
+
+	return mdFiles, nil
+}
@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Credentials authenticate a remote with its upstream, the values are
+// registered for redaction when they are read from the configuration
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// authorize adds a bearer token or basic auth header to req
+func (c Credentials) authorize(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+func (c Credentials) repoOptions() []string {
+	var options []string
+
+	if c.Username != "" {
+		options = append(options, "username="+c.Username)
+	}
+
+	if c.Password != "" {
+		options = append(options, "password="+c.Password)
+	}
+
+	return options
+}
+
+// rsyncUrlWithUser adds the username to an rsync url which does not contain one
+func rsyncUrlWithUser(src string, username string) (string, error) {
+	if username == "" {
+		return src, nil
+	}
+
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", errors.Wrap(err, "incorrect rsync url")
+	}
+
+	if u.User == nil {
+		u.User = url.User(username)
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,45 @@
+package remote
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestCredentialsAuthorize(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodHead, "https://repo.example.com/repodata/repomd.xml", nil)
+	Credentials{Token: "t0ken"}.authorize(req)
+	assert.Equal(t, req.Header.Get("Authorization"), "Bearer t0ken")
+
+	req, _ = http.NewRequest(http.MethodHead, "https://repo.example.com/repodata/repomd.xml", nil)
+	Credentials{Username: "mirror", Password: "s3cr3t"}.authorize(req)
+	username, password, ok := req.BasicAuth()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, username, "mirror")
+	assert.Equal(t, password, "s3cr3t")
+
+	req, _ = http.NewRequest(http.MethodHead, "https://repo.example.com/repodata/repomd.xml", nil)
+	Credentials{}.authorize(req)
+	assert.Equal(t, req.Header.Get("Authorization"), "")
+}
+
+func TestRsyncUrlWithUser(t *testing.T) {
+	var tests = []struct {
+		src      string
+		username string
+		expected string
+	}{
+		{"rsync://mirror.example.com/centos/", "", "rsync://mirror.example.com/centos/"},
+		{"rsync://mirror.example.com/centos/", "mirror", "rsync://mirror@mirror.example.com/centos/"},
+		{"rsync://other@mirror.example.com/centos/", "mirror", "rsync://other@mirror.example.com/centos/"},
+	}
+	for i, test := range tests {
+		src, err := rsyncUrlWithUser(test.src, test.username)
+
+		assert.Equal(t, err, nil)
+		if src != test.expected {
+			t.Errorf("Test: %d expected %s, got %s", i, test.expected, src)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package remote
 
 import (
+	"context"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -8,7 +10,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 type DummyRemote struct {
@@ -27,11 +29,11 @@ func (r DummyRemote) Init() error {
 	return nil
 }
 
-func (r DummyRemote) Sync() error {
+func (r DummyRemote) Sync(ctx context.Context) (SyncResult, error) {
 	rand.Seed(time.Now().UnixNano())
 	sleeptime := rand.Intn(30)
 
-	log.Debug().Str("repo", r.id).Int("sleep", sleeptime).Msg("Dummy sync sleeping")
+	zerolog.Ctx(ctx).Debug().Int("sleep", sleeptime).Msg("Dummy sync sleeping")
 
 	time.Sleep(time.Duration(sleeptime) * time.Second)
 
@@ -44,20 +46,40 @@ func (r DummyRemote) Sync() error {
 	if randErr() {
 		err := errors.New("dummy sync error")
 
-		log.Error().Stack().Err(err).Str("repo", r.id).Msg("")
+		zerolog.Ctx(ctx).Error().Stack().Err(err).Msg("")
 
-		return err
+		return SyncResult{}, err
 	} else {
 		dummyFile, err := os.Create(filepath.Join(r.dest, uuid.New().String()))
 
 		if err != nil {
-			return err
+			return SyncResult{}, err
 		}
 
-		return dummyFile.Close()
+		// Write some random content subject to the bandwidth limit
+		size := rand.Int63n(1024 * 1024)
+		data := newRateLimitedReader(ctx, io.LimitReader(rand.New(rand.NewSource(time.Now().UnixNano())), size))
+
+		if _, err := io.Copy(dummyFile, data); err != nil {
+			dummyFile.Close()
+
+			return SyncResult{}, err
+		}
+
+		if err := dummyFile.Close(); err != nil {
+			return SyncResult{}, err
+		}
+
+		result := SyncResult{Changed: true, FilesAdded: 1, BytesTransferred: size}
+
+		if m, err := buildManifest(r.dest); err == nil {
+			result.TotalSize = m.totalSize()
+		}
+
+		return result, nil
 	}
 }
 
-func (r DummyRemote) Publish(snapshot string) error {
+func (r DummyRemote) Publish(ctx context.Context, snapshot string) error {
 	return nil
 }
@@ -0,0 +1,174 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/klaasjand/lagoon/internal/secret"
+	"github.com/pkg/errors"
+)
+
+// HTTPOptions configures how a remote reaches an HTTP(S) upstream
+type HTTPOptions struct {
+	Proxy       string
+	CACert      string
+	ClientCert  string
+	ClientKey   string
+	Credentials Credentials
+}
+
+// validate checks that the configured files exist and that the client
+// certificate is currently valid
+func (o HTTPOptions) validate() error {
+	if o.Proxy != "" {
+		if u, err := url.Parse(o.Proxy); err != nil {
+			return errors.Wrap(err, "invalid proxy url")
+		} else if password, ok := u.User.Password(); ok {
+			secret.Register(password)
+		}
+	}
+
+	if o.CACert != "" {
+		if _, err := o.certPool(); err != nil {
+			return err
+		}
+	}
+
+	if (o.ClientCert == "") != (o.ClientKey == "") {
+		return errors.New("client certificate and key must be configured together")
+	}
+
+	if o.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return errors.Wrap(err, "unable to load client certificate")
+		}
+
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return errors.Wrap(err, "unable to parse client certificate")
+		}
+
+		if err := checkValidity(cert, time.Now()); err != nil {
+			return errors.Wrapf(err, "client certificate %s", o.ClientCert)
+		}
+	}
+
+	return nil
+}
+
+func checkValidity(cert *x509.Certificate, t time.Time) error {
+	if t.Before(cert.NotBefore) {
+		return errors.Errorf("not valid before %s", cert.NotBefore)
+	}
+
+	if t.After(cert.NotAfter) {
+		return errors.Errorf("expired at %s", cert.NotAfter)
+	}
+
+	return nil
+}
+
+func (o HTTPOptions) certPool() (*x509.CertPool, error) {
+	data, err := os.ReadFile(o.CACert)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read CA bundle")
+	}
+
+	if block, _ := pem.Decode(data); block == nil {
+		return nil, errors.Errorf("no certificates found in CA bundle %s", o.CACert)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificates found in CA bundle %s", o.CACert)
+	}
+
+	return pool, nil
+}
+
+// client returns an HTTP client using the proxy and TLS settings, without a
+// proxy configured the proxy environment variables are used
+func (o HTTPOptions) client() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if o.Proxy != "" {
+		proxyUrl, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy url")
+		}
+
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if o.CACert != "" {
+		pool, err := o.certPool()
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load client certificate")
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// repoOptions translates the settings into yum repo options
+func (o HTTPOptions) repoOptions() []string {
+	var options []string
+
+	if o.Proxy != "" {
+		options = append(options, "proxy="+o.Proxy)
+	}
+
+	if o.CACert != "" {
+		options = append(options, "sslcacert="+o.CACert)
+	}
+
+	if o.ClientCert != "" {
+		options = append(options, "sslclientcert="+o.ClientCert, "sslclientkey="+o.ClientKey)
+	}
+
+	return append(options, o.Credentials.repoOptions()...)
+}
+
+// rsyncProxy returns the proxy in the [user:pass@]host:port format expected
+// by the RSYNC_PROXY environment variable
+func rsyncProxy(proxy string) (string, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid proxy url")
+	}
+
+	if u.Host == "" {
+		return "", errors.Errorf("invalid proxy url '%s'", proxy)
+	}
+
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			secret.Register(password)
+		}
+
+		return u.User.String() + "@" + u.Host, nil
+	}
+
+	return u.Host, nil
+}
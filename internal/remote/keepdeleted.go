@@ -0,0 +1,230 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const deletedFilesFile = "deleted.json"
+
+// deletedFiles keeps track of when files which are kept in the upstream
+// folder were deleted upstream, it is stored in the state path so the grace
+// period survives restarts
+type deletedFiles struct {
+	path  string
+	files map[string]time.Time
+}
+
+func loadDeletedFiles(statePath string) (*deletedFiles, error) {
+	d := &deletedFiles{
+		path:  filepath.Join(statePath, deletedFilesFile),
+		files: map[string]time.Time{},
+	}
+
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &d.files); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", d.path)
+	}
+
+	return d, nil
+}
+
+func (d *deletedFiles) save() error {
+	data, err := json.MarshalIndent(d.files, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(d.path, data, 0644)
+}
+
+// update records the files of root which are no longer upstream, deleted holds
+// their paths relative to root. Files which were deleted longer than grace
+// ago are removed from root and returned, files which are upstream again are
+// no longer tracked.
+func (d *deletedFiles) update(root string, deleted []string, grace time.Duration, now time.Time) ([]string, error) {
+	current := map[string]bool{}
+	for _, f := range deleted {
+		current[f] = true
+
+		if _, ok := d.files[f]; !ok {
+			d.files[f] = now
+		}
+	}
+
+	var pruned []string
+
+	for f, deletedAt := range d.files {
+		if !current[f] {
+			delete(d.files, f)
+
+			continue
+		}
+
+		if now.Sub(deletedAt) < grace {
+			continue
+		}
+
+		// Snapshots hold their own hardlink, only the upstream copy is removed
+		if err := os.Remove(filepath.Join(root, f)); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		delete(d.files, f)
+		pruned = append(pruned, f)
+	}
+
+	sort.Strings(pruned)
+
+	return pruned, nil
+}
+
+// keepDeleted updates the files deleted upstream which are kept in root and
+// prunes those past the grace period. It returns the number of kept files and
+// the pruned files.
+func keepDeleted(ctx context.Context, statePath string, root string, deleted []string, grace time.Duration) (int, []string, error) {
+	d, err := loadDeletedFiles(statePath)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pruned, err := d.update(root, deleted, grace, time.Now())
+
+	if saveErr := d.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, f := range pruned {
+		zerolog.Ctx(ctx).Info().Str("file", f).Msg("Pruned file deleted upstream after grace period")
+	}
+
+	return len(d.files), pruned, nil
+}
+
+// KeptFiles returns the paths relative to the upstream root of the files which
+// are kept although they were deleted upstream
+func KeptFiles(statePath string) ([]string, error) {
+	d, err := loadDeletedFiles(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for f := range d.files {
+		files = append(files, f)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// deletedPackages returns the packages in the repository at root which are no
+// longer listed in its primary metadata, relative to root
+func deletedPackages(ctx context.Context, root string) ([]string, error) {
+	primary, err := readUpstreamMetadata(ctx, root, "primary")
+	if err != nil {
+		return nil, err
+	} else if primary == nil {
+		return nil, errors.New("primary metadata not found")
+	}
+
+	// Packages are compared by file name, reposync does not always keep the
+	// folder structure of the upstream
+	listed := map[string]bool{}
+
+	decoder := xml.NewDecoder(bytes.NewReader(primary))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "unable to decode primary metadata")
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "location" {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "href" {
+					listed[path.Base(attr.Value)] = true
+				}
+			}
+		}
+	}
+
+	var deleted []string
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".rpm") || listed[d.Name()] {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		deleted = append(deleted, rel)
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+// repoRoots returns the repositories below root which hold any of the files,
+// a repository is the closest parent folder with a repomd.xml
+func repoRoots(root string, files []string) []string {
+	root = filepath.Clean(root)
+
+	seen := map[string]bool{}
+
+	var roots []string
+
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".rpm") {
+			continue
+		}
+
+		for dir := filepath.Dir(filepath.Join(root, f)); strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+			if _, err := os.Stat(filepath.Join(dir, "repodata", "repomd.xml")); err == nil {
+				if !seen[dir] {
+					seen[dir] = true
+					roots = append(roots, dir)
+				}
+
+				break
+			}
+
+			if dir == root {
+				break
+			}
+		}
+	}
+
+	sort.Strings(roots)
+
+	return roots
+}
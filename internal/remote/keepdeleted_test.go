@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for f, content := range files {
+		path := filepath.Join(root, f)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeletedFilesUpdate(t *testing.T) {
+	root := t.TempDir()
+	statePath := t.TempDir()
+
+	writeTestFiles(t, root, map[string]string{
+		"Packages/old-1.0.rpm":   "",
+		"Packages/older-0.9.rpm": "",
+	})
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	grace := 30 * 24 * time.Hour
+
+	d, err := loadDeletedFiles(statePath)
+	assert.Equal(t, err, nil)
+
+	pruned, err := d.update(root, []string{"Packages/old-1.0.rpm", "Packages/older-0.9.rpm", "Packages/back-1.0.rpm"}, grace, start)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(pruned), 0)
+	assert.Equal(t, len(d.files), 3)
+	assert.Equal(t, d.save(), nil)
+
+	// The deletion time is remembered between runs and files which are
+	// upstream again are no longer tracked
+	d, err = loadDeletedFiles(statePath)
+	assert.Equal(t, err, nil)
+
+	pruned, err = d.update(root, []string{"Packages/old-1.0.rpm", "Packages/older-0.9.rpm"}, grace, start.Add(grace-time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(pruned), 0)
+	assert.Equal(t, len(d.files), 2)
+
+	pruned, err = d.update(root, []string{"Packages/old-1.0.rpm", "Packages/older-0.9.rpm"}, grace, start.Add(grace))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pruned, []string{"Packages/old-1.0.rpm", "Packages/older-0.9.rpm"})
+	assert.Equal(t, len(d.files), 0)
+
+	_, err = os.Stat(filepath.Join(root, "Packages/old-1.0.rpm"))
+	assert.Equal(t, os.IsNotExist(err), true)
+}
+
+func TestDeletedPackages(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFiles(t, root, map[string]string{
+		"repodata/repomd.xml": `<repomd><data type="primary"><location href="repodata/primary.xml"/></data></repomd>`,
+		"repodata/primary.xml": `<metadata packages="2">
+  <package type="rpm"><name>bash</name><location href="Packages/b/bash-5.1-1.x86_64.rpm"/></package>
+  <package type="rpm"><name>zsh</name><location href="Packages/z/zsh-5.8-1.x86_64.rpm"/></package>
+</metadata>`,
+		"Packages/b/bash-5.1-1.x86_64.rpm": "",
+		"Packages/b/bash-5.0-1.x86_64.rpm": "",
+		"zsh-5.8-1.x86_64.rpm":             "",
+	})
+
+	deleted, err := deletedPackages(context.Background(), root)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, []string{"Packages/b/bash-5.0-1.x86_64.rpm"})
+
+	_, err = deletedPackages(context.Background(), t.TempDir())
+	assert.NotEqual(t, err, nil)
+}
+
+func TestRepoRoots(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFiles(t, root, map[string]string{
+		"7/os/x86_64/repodata/repomd.xml":      "",
+		"7/updates/x86_64/repodata/repomd.xml": "",
+	})
+
+	roots := repoRoots(root+"/", []string{
+		"7/os/x86_64/Packages/bash-4.2-1.x86_64.rpm",
+		"7/os/x86_64/Packages/zsh-5.0-1.x86_64.rpm",
+		"7/updates/x86_64/Packages/bash-4.2-2.x86_64.rpm",
+		"7/isos/x86_64/CentOS-7-x86_64-Minimal.iso",
+		"7/extras/x86_64/Packages/docker-1.13.rpm",
+	})
+
+	assert.Equal(t, roots, []string{filepath.Join(root, "7/os/x86_64"), filepath.Join(root, "7/updates/x86_64")})
+}
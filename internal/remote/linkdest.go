@@ -0,0 +1,19 @@
+package remote
+
+import "context"
+
+type linkDestKey struct{}
+
+// WithLinkDest returns a context which makes rsync hardlink files which are
+// unchanged since the snapshot at dir instead of transferring them
+func WithLinkDest(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, linkDestKey{}, dir)
+}
+
+func linkDest(ctx context.Context) string {
+	if dir, ok := ctx.Value(linkDestKey{}).(string); ok {
+		return dir
+	}
+
+	return ""
+}
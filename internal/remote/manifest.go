@@ -0,0 +1,96 @@
+package remote
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// manifest maps the relative path of every regular file in a tree to its state
+type manifest map[string]fileState
+
+func buildManifest(root string) (manifest, error) {
+	m := manifest{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		m[rel] = fileState{size: info.Size(), modTime: info.ModTime()}
+
+		return nil
+	})
+
+	return m, err
+}
+
+func (m manifest) totalSize() int64 {
+	var size int64
+
+	for _, f := range m {
+		size += f.size
+	}
+
+	return size
+}
+
+// diffManifests compares the state of a tree before and after a sync
+func diffManifests(before manifest, after manifest) SyncResult {
+	result := SyncResult{TotalSize: after.totalSize()}
+
+	for path, a := range after {
+		if b, ok := before[path]; !ok {
+			result.FilesAdded++
+			result.BytesTransferred += a.size
+		} else if a.size != b.size || !a.modTime.Equal(b.modTime) {
+			result.FilesUpdated++
+			result.BytesTransferred += a.size
+		}
+	}
+
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			result.FilesDeleted++
+		}
+	}
+
+	result.Changed = result.FilesAdded+result.FilesUpdated+result.FilesDeleted > 0
+
+	return result
+}
+
+// DiffTrees compares the regular files of two trees by size and modification
+// time, as if after was the result of syncing into before
+func DiffTrees(before string, after string) (SyncResult, error) {
+	b, err := buildManifest(before)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	a, err := buildManifest(after)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	return diffManifests(b, a), nil
+}
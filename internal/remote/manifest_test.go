@@ -0,0 +1,38 @@
+package remote
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestDiffManifests(t *testing.T) {
+	now := time.Now()
+
+	before := manifest{
+		"a.rpm": {size: 10, modTime: now},
+		"b.rpm": {size: 20, modTime: now},
+		"c.rpm": {size: 30, modTime: now},
+	}
+	after := manifest{
+		"a.rpm": {size: 10, modTime: now},
+		"b.rpm": {size: 25, modTime: now.Add(time.Minute)},
+		"d.rpm": {size: 40, modTime: now},
+	}
+
+	result := diffManifests(before, after)
+
+	assert.Equal(t, result.Changed, true)
+	assert.Equal(t, result.FilesAdded, 1)
+	assert.Equal(t, result.FilesUpdated, 1)
+	assert.Equal(t, result.FilesDeleted, 1)
+	assert.Equal(t, result.BytesTransferred, int64(65))
+	assert.Equal(t, result.TotalSize, int64(75))
+}
+
+func TestDiffManifestsUnchanged(t *testing.T) {
+	m := manifest{"a.rpm": {size: 10, modTime: time.Now()}}
+
+	assert.Equal(t, diffManifests(m, m).Changed, false)
+}
@@ -0,0 +1,297 @@
+package remote
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// xmlNode is a generic XML element which survives a decode and encode round
+// trip, it is used to edit metadata without knowing its complete schema
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+// trimSpace drops whitespace between child elements, the encoder indents the
+// output instead
+func (n *xmlNode) trimSpace() {
+	if len(n.Nodes) > 0 && strings.TrimSpace(n.Content) == "" {
+		n.Content = ""
+	}
+
+	for i := range n.Nodes {
+		n.Nodes[i].trimSpace()
+	}
+}
+
+func (n xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+func (n xmlNode) child(name string) (xmlNode, bool) {
+	for _, c := range n.Nodes {
+		if c.XMLName.Local == name {
+			return c, true
+		}
+	}
+
+	return xmlNode{}, false
+}
+
+type repomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+		Checksum struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"checksum"`
+	} `xml:"data"`
+}
+
+// repomdLocation returns the location of the metadata of type mdType listed
+// in the repomd.xml of the repository at path
+func repomdLocation(path string, mdType string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(path, "repodata", "repomd.xml"))
+	if err != nil {
+		return "", err
+	}
+
+	var md repomd
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return "", errors.Wrap(err, "unable to decode repomd.xml")
+	}
+
+	for _, d := range md.Data {
+		if d.Type == mdType {
+			return filepath.Join(path, filepath.FromSlash(d.Location.Href)), nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
+
+// findMetadata returns the metadata file of type mdType in a repository, it is
+// either listed in the repomd.xml or, as yum-utils reposync stores additional
+// metadata, in the root of the repository
+func findMetadata(path string, mdType string) (string, error) {
+	if location, err := repomdLocation(path, mdType); err == nil {
+		if _, err := os.Stat(location); err == nil {
+			return location, nil
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(path, "*"+mdType+".*"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, m := range matches {
+		if strings.Contains(filepath.Base(m), mdType+".xml") || strings.Contains(filepath.Base(m), mdType+".yaml") {
+			return m, nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
+
+// readUpstreamMetadata returns the uncompressed metadata of type mdType of the
+// repository at path, or nil when the repository has no such metadata
+func readUpstreamMetadata(ctx context.Context, path string, mdType string) ([]byte, error) {
+	src, err := findMetadata(path, mdType)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := readMetadata(ctx, src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s metadata %s", mdType, src)
+	}
+
+	return data, nil
+}
+
+// verifyMetadata checks that the repomd.xml of the repository at path lists
+// metadata of type mdType and that the metadata file exists
+func verifyMetadata(path string, mdType string) error {
+	location, err := repomdLocation(path, mdType)
+	if err != nil {
+		return errors.Wrapf(err, "%s metadata missing from repomd.xml of %s", mdType, path)
+	}
+
+	if _, err := os.Stat(location); err != nil {
+		return errors.Wrapf(err, "%s metadata listed in repomd.xml of %s", mdType, path)
+	}
+
+	return nil
+}
+
+// readMetadata reads a possibly compressed metadata file
+func readMetadata(ctx context.Context, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		r = gz
+	case ".bz2":
+		r = bzip2.NewReader(f)
+	case ".xz":
+		// The standard library has no xz support
+		return exec.CommandContext(ctx, "xz", "-dc", path).Output()
+	}
+
+	return io.ReadAll(r)
+}
+
+// rpmFiles returns the base names of all packages in a repository
+func rpmFiles(path string) (map[string]bool, error) {
+	files := map[string]bool{}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".rpm") {
+			files[d.Name()] = true
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// trimUpdateInfo removes packages which are not present from the advisories
+// in an updateinfo.xml and drops advisories without any package left
+func trimUpdateInfo(data []byte, present map[string]bool) ([]byte, int, error) {
+	var root xmlNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, 0, errors.Wrap(err, "unable to decode updateinfo")
+	}
+
+	var updates []xmlNode
+	for _, update := range root.Nodes {
+		if update.XMLName.Local != "update" {
+			updates = append(updates, update)
+
+			continue
+		}
+
+		if trimAdvisory(&update, present) {
+			updates = append(updates, update)
+		}
+	}
+
+	dropped := len(root.Nodes) - len(updates)
+	root.Nodes = updates
+	root.trimSpace()
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), dropped, nil
+}
+
+// trimAdvisory removes packages which are not present from an advisory and
+// reports whether any package is left
+func trimAdvisory(update *xmlNode, present map[string]bool) bool {
+	found := false
+
+	for i, pkglist := range update.Nodes {
+		if pkglist.XMLName.Local != "pkglist" {
+			continue
+		}
+
+		for j, collection := range pkglist.Nodes {
+			var nodes []xmlNode
+
+			for _, pkg := range collection.Nodes {
+				if pkg.XMLName.Local != "package" {
+					nodes = append(nodes, pkg)
+				} else if present[packageFilename(pkg)] {
+					nodes = append(nodes, pkg)
+					found = true
+				}
+			}
+
+			update.Nodes[i].Nodes[j].Nodes = nodes
+		}
+	}
+
+	return found
+}
+
+func packageFilename(pkg xmlNode) string {
+	if filename, ok := pkg.child("filename"); ok {
+		return filepath.Base(strings.TrimSpace(filename.Content))
+	}
+
+	return pkg.attr("name") + "-" + pkg.attr("version") + "-" + pkg.attr("release") + "." + pkg.attr("arch") + ".rpm"
+}
+
+// modifyRepoCommand returns the available modifyrepo implementation
+func modifyRepoCommand() (string, error) {
+	if path, err := exec.LookPath("modifyrepo_c"); err == nil {
+		return path, nil
+	}
+
+	return exec.LookPath("modifyrepo")
+}
+
+// addMetadata adds a metadata file to the repodata of the repository at path,
+// the metadata type is derived from the name of the file
+func addMetadata(ctx context.Context, path string, file string) error {
+	modifyRepo, err := modifyRepoCommand()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, modifyRepo, file, filepath.Join(path, "repodata"))
+
+	zerolog.Ctx(ctx).Debug().Str("command", cmd.String()).Msg("Executing modifyrepo")
+
+	return runCommand(ctx, cmd, nil)
+}
@@ -0,0 +1,138 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+const testUpdateInfo = `<?xml version="1.0" encoding="UTF-8"?>
+<updates>
+  <update from="security@example.com" status="final" type="security" version="1">
+    <id>ALSA-2022:0001</id>
+    <title>Important: bash security update</title>
+    <issued date="2022-01-01 00:00:00"/>
+    <references>
+      <reference href="https://errata.example.com/ALSA-2022-0001.html" id="ALSA-2022:0001" type="self"/>
+    </references>
+    <description>Fixes &lt;CVE-2022-0001&gt;</description>
+    <pkglist>
+      <collection short="almalinux-8">
+        <name>almalinux-8</name>
+        <package name="bash" version="4.4.20" release="3.el8" epoch="0" arch="x86_64">
+          <filename>bash-4.4.20-3.el8.x86_64.rpm</filename>
+        </package>
+        <package name="bash-doc" version="4.4.20" release="3.el8" epoch="0" arch="x86_64">
+          <filename>bash-doc-4.4.20-3.el8.x86_64.rpm</filename>
+        </package>
+      </collection>
+    </pkglist>
+  </update>
+  <update from="security@example.com" status="final" type="bugfix" version="1">
+    <id>ALBA-2022:0002</id>
+    <pkglist>
+      <collection short="almalinux-8">
+        <package name="nginx" version="1.20.1" release="1.el8" epoch="0" arch="x86_64"/>
+      </collection>
+    </pkglist>
+  </update>
+</updates>
+`
+
+func TestTrimUpdateInfo(t *testing.T) {
+	present := map[string]bool{"bash-4.4.20-3.el8.x86_64.rpm": true}
+
+	data, dropped, err := trimUpdateInfo([]byte(testUpdateInfo), present)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dropped, 1)
+
+	var root xmlNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		t.Fatalf("Trimmed updateinfo should be valid XML: %v", err)
+	}
+
+	assert.Equal(t, len(root.Nodes), 1)
+
+	out := string(data)
+	if !strings.Contains(out, "bash-4.4.20-3.el8.x86_64.rpm") || strings.Contains(out, "bash-doc") || strings.Contains(out, "nginx") {
+		t.Errorf("Unexpected trimmed updateinfo: %s", out)
+	}
+
+	if !strings.Contains(out, "&lt;CVE-2022-0001&gt;") || !strings.Contains(out, `type="self"`) {
+		t.Errorf("Advisory content should be preserved: %s", out)
+	}
+}
+
+func TestPackageFilename(t *testing.T) {
+	pkg := xmlNode{Attrs: []xml.Attr{
+		{Name: xml.Name{Local: "name"}, Value: "nginx"},
+		{Name: xml.Name{Local: "version"}, Value: "1.20.1"},
+		{Name: xml.Name{Local: "release"}, Value: "1.el8"},
+		{Name: xml.Name{Local: "arch"}, Value: "x86_64"},
+	}}
+
+	assert.Equal(t, packageFilename(pkg), "nginx-1.20.1-1.el8.x86_64.rpm")
+
+	pkg.Nodes = []xmlNode{{XMLName: xml.Name{Local: "filename"}, Content: "Packages/n/nginx-1.20.1-1.el8.x86_64.rpm"}}
+
+	assert.Equal(t, packageFilename(pkg), "nginx-1.20.1-1.el8.x86_64.rpm")
+}
+
+func TestReadAndVerifyMetadata(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, "repodata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	repomdXml := `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary">
+    <location href="repodata/abc-primary.xml.gz"/>
+  </data>
+  <data type="modules">
+    <location href="repodata/def-modules.yaml.gz"/>
+  </data>
+</repomd>`
+
+	if err := os.WriteFile(filepath.Join(dir, "repodata", "repomd.xml"), []byte(repomdXml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("---\ndocument: modulemd\n"))
+	gz.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, "repodata", "def-modules.yaml.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	modules, err := readUpstreamMetadata(context.Background(), dir, "modules")
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(modules), "---\ndocument: modulemd\n")
+
+	updateInfo, err := readUpstreamMetadata(context.Background(), dir, "updateinfo")
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updateInfo, nil)
+
+	assert.Equal(t, verifyMetadata(dir, "modules"), nil)
+
+	if err := verifyMetadata(dir, "updateinfo"); err == nil {
+		t.Errorf("Missing metadata should result in error")
+	}
+
+	if err := verifyMetadata(dir, "primary"); err == nil {
+		t.Errorf("Metadata listed in repomd.xml without file should result in error")
+	}
+}
@@ -0,0 +1,220 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const mirrorHealthFile = "mirrors.json"
+
+type mirrorHealth struct {
+	Failures    int       `json:"failures"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+}
+
+// mirrorSet keeps track of the health of the mirrors of an upstream, the
+// health is stored in the state path so it is remembered between runs
+type mirrorSet struct {
+	path    string
+	mirrors []string
+	health  map[string]*mirrorHealth
+}
+
+func loadMirrorSet(statePath string, mirrors []string) (*mirrorSet, error) {
+	s := &mirrorSet{
+		path:    filepath.Join(statePath, mirrorHealthFile),
+		mirrors: mirrors,
+		health:  map[string]*mirrorHealth{},
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.health); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", s.path)
+	}
+
+	return s, nil
+}
+
+// ordered returns the mirrors with the least consecutive failures first,
+// mirrors with an equal number of failures keep their configured order
+func (s *mirrorSet) ordered() []string {
+	ordered := append([]string{}, s.mirrors...)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.failures(ordered[i]) < s.failures(ordered[j])
+	})
+
+	return ordered
+}
+
+func (s *mirrorSet) failures(mirror string) int {
+	if h, ok := s.health[mirror]; ok {
+		return h.Failures
+	}
+
+	return 0
+}
+
+func (s *mirrorSet) get(mirror string) *mirrorHealth {
+	if _, ok := s.health[mirror]; !ok {
+		s.health[mirror] = &mirrorHealth{}
+	}
+
+	return s.health[mirror]
+}
+
+func (s *mirrorSet) markSuccess(mirror string) {
+	h := s.get(mirror)
+	h.Failures = 0
+	h.LastSuccess = time.Now()
+}
+
+func (s *mirrorSet) markFailure(mirror string) {
+	h := s.get(mirror)
+	h.Failures++
+	h.LastFailure = time.Now()
+}
+
+// save stores the health of the current mirrors, mirrors which are no longer
+// configured or listed by the mirrorlist are forgotten
+func (s *mirrorSet) save() error {
+	health := map[string]*mirrorHealth{}
+	for _, mirror := range s.mirrors {
+		if h, ok := s.health[mirror]; ok {
+			health[mirror] = h
+		}
+	}
+
+	data, err := json.MarshalIndent(health, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// syncMirrors calls fn for each mirror, healthiest first, until a sync
+// succeeds. The mirror used is recorded in the result.
+func syncMirrors(ctx context.Context, statePath string, mirrors []string, fn func(mirror string) (SyncResult, error)) (SyncResult, error) {
+	set, err := loadMirrorSet(statePath, mirrors)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	defer func() {
+		if err := set.save(); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Unable to save mirror health")
+		}
+	}()
+
+	var lastErr error
+
+	for _, mirror := range set.ordered() {
+		result, err := fn(mirror)
+		if err == nil {
+			set.markSuccess(mirror)
+			result.Mirror = mirror
+
+			return result, nil
+		}
+
+		set.markFailure(mirror)
+		lastErr = err
+
+		zerolog.Ctx(ctx).Warn().Err(err).Str("mirror", mirror).Msg("Mirror failed, trying next mirror")
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no mirrors configured")
+	}
+
+	return SyncResult{}, errors.Wrap(lastErr, "all mirrors failed")
+}
+
+type metalink struct {
+	Urls []struct {
+		Protocol string `xml:"protocol,attr"`
+		Url      string `xml:",chardata"`
+	} `xml:"files>file>resources>url"`
+}
+
+// fetchMirrorlist downloads a yum mirrorlist or metalink and returns the base
+// urls of the mirrors it contains
+func fetchMirrorlist(ctx context.Context, client *http.Client, creds Credentials, listUrl string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.authorize(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.Errorf("fetching mirrorlist failed with status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMirrorlist(data)
+}
+
+func parseMirrorlist(data []byte) ([]string, error) {
+	var mirrors []string
+
+	if strings.Contains(string(data), "<metalink") {
+		var ml metalink
+
+		if err := xml.Unmarshal(data, &ml); err != nil {
+			return nil, errors.Wrap(err, "unable to decode metalink")
+		}
+
+		for _, u := range ml.Urls {
+			if u.Protocol != "http" && u.Protocol != "https" {
+				continue
+			}
+
+			// Metalink urls point to the repomd.xml of the mirror
+			mirrors = append(mirrors, strings.TrimSuffix(strings.TrimSpace(u.Url), "repodata/repomd.xml"))
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+
+			if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+				mirrors = append(mirrors, line)
+			}
+		}
+	}
+
+	if len(mirrors) == 0 {
+		return nil, errors.New("no mirrors found in mirrorlist")
+	}
+
+	return mirrors, nil
+}
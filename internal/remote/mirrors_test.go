@@ -0,0 +1,122 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/pkg/errors"
+)
+
+func TestSyncMirrors(t *testing.T) {
+	dir := t.TempDir()
+	mirrors := []string{"rsync://a/", "rsync://b/", "rsync://c/"}
+
+	var tried []string
+
+	result, err := syncMirrors(context.Background(), dir, mirrors, func(mirror string) (SyncResult, error) {
+		tried = append(tried, mirror)
+
+		if mirror == "rsync://a/" {
+			return SyncResult{}, errors.New("mirror down")
+		}
+
+		return SyncResult{Changed: true}, nil
+	})
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Mirror, "rsync://b/")
+	assert.Equal(t, tried, []string{"rsync://a/", "rsync://b/"})
+
+	// The failed mirror is tried last in the next run
+	set, err := loadMirrorSet(dir, mirrors)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, set.ordered(), []string{"rsync://b/", "rsync://c/", "rsync://a/"})
+}
+
+func TestSyncMirrorsForget(t *testing.T) {
+	dir := t.TempDir()
+
+	fail := func(mirror string) (SyncResult, error) {
+		return SyncResult{}, errors.New("mirror down")
+	}
+
+	_, _ = syncMirrors(context.Background(), dir, []string{"http://a/", "http://b/"}, fail)
+
+	// The mirrorlist no longer lists a
+	_, _ = syncMirrors(context.Background(), dir, []string{"http://b/", "http://c/"}, fail)
+
+	set, err := loadMirrorSet(dir, nil)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(set.health), 2)
+	assert.Equal(t, set.failures("http://a/"), 0)
+	assert.Equal(t, set.failures("http://b/"), 2)
+	assert.Equal(t, set.failures("http://c/"), 1)
+}
+
+func TestSyncMirrorsAllFailed(t *testing.T) {
+	_, err := syncMirrors(context.Background(), t.TempDir(), []string{"rsync://a/"}, func(mirror string) (SyncResult, error) {
+		return SyncResult{}, errors.New("mirror down")
+	})
+
+	if err == nil {
+		t.Errorf("Sync should fail when all mirrors fail")
+	}
+}
+
+func TestParseMirrorlist(t *testing.T) {
+	mirrorlist := `# repo = baseos arch = x86_64 country = NL
+https://mirror1.example.com/8/BaseOS/x86_64/os/
+http://mirror2.example.com/8/BaseOS/x86_64/os/
+`
+
+	mirrors, err := parseMirrorlist([]byte(mirrorlist))
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mirrors, []string{"https://mirror1.example.com/8/BaseOS/x86_64/os/", "http://mirror2.example.com/8/BaseOS/x86_64/os/"})
+
+	metalink := `<?xml version="1.0" encoding="utf-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+ <files>
+  <file name="repomd.xml">
+   <resources maxconnections="1">
+    <url protocol="https" type="https" location="NL" preference="100">https://mirror1.example.com/9/BaseOS/x86_64/os/repodata/repomd.xml</url>
+    <url protocol="rsync" type="rsync" location="NL" preference="99">rsync://mirror1.example.com/9/BaseOS/x86_64/os/repodata/repomd.xml</url>
+   </resources>
+  </file>
+ </files>
+</metalink>`
+
+	mirrors, err = parseMirrorlist([]byte(metalink))
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mirrors, []string{"https://mirror1.example.com/9/BaseOS/x86_64/os/"})
+
+	if _, err := parseMirrorlist([]byte("# empty")); err == nil {
+		t.Errorf("Mirrorlist without mirrors should result in error")
+	}
+}
+
+func TestFetchMirrorlist(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		w.Write([]byte("https://mirror1.example.com/8/BaseOS/x86_64/os/\n"))
+	}))
+	defer server.Close()
+
+	_, err := fetchMirrorlist(context.Background(), server.Client(), Credentials{}, server.URL)
+	assert.NotEqual(t, err, nil)
+
+	mirrors, err := fetchMirrorlist(context.Background(), server.Client(), Credentials{Token: "t0ken"}, server.URL)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, mirrors, []string{"https://mirror1.example.com/8/BaseOS/x86_64/os/"})
+}
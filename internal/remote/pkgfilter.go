@@ -0,0 +1,68 @@
+package remote
+
+import (
+	"strings"
+)
+
+// PackageFilter limits which packages of an RPM repository are mirrored
+type PackageFilter struct {
+	// Include and Exclude contain package name globs
+	Include []string
+	Exclude []string
+	// Arch limits packages to these architectures, noarch is always included
+	Arch         []string
+	NewestOnly   bool
+	KeepVersions int
+}
+
+// keep returns the number of versions of each package to keep, or 0 to keep all
+func (f PackageFilter) keep() int {
+	if f.NewestOnly {
+		return 1
+	}
+
+	return f.KeepVersions
+}
+
+// repoOptions translates the filter into yum repo options, the architecture
+// filter is expressed as includepkgs globs matching name.arch
+func (f PackageFilter) repoOptions() []string {
+	var options []string
+
+	includes := f.Include
+	if len(includes) == 0 && len(f.Arch) > 0 {
+		includes = []string{"*"}
+	}
+
+	var includePkgs []string
+	for _, inc := range includes {
+		if len(f.Arch) == 0 {
+			includePkgs = append(includePkgs, inc)
+
+			continue
+		}
+
+		for _, arch := range append(append([]string{}, f.Arch...), "noarch") {
+			includePkgs = append(includePkgs, inc+"."+arch)
+		}
+	}
+
+	if len(includePkgs) > 0 {
+		options = append(options, "includepkgs="+strings.Join(includePkgs, " "))
+	}
+
+	if len(f.Exclude) > 0 {
+		options = append(options, "exclude="+strings.Join(f.Exclude, " "))
+	}
+
+	return options
+}
+
+// appendRepoOptions appends yum options to the repo section in src
+func appendRepoOptions(src string, options []string) string {
+	if len(options) == 0 {
+		return src
+	}
+
+	return strings.TrimRight(src, "\n") + "\n" + strings.Join(options, "\n") + "\n"
+}
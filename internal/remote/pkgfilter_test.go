@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestPackageFilterRepoOptions(t *testing.T) {
+	var tests = []struct {
+		filter   PackageFilter
+		expected []string
+	}{
+		{PackageFilter{}, nil},
+		{PackageFilter{Include: []string{"kernel*", "bash"}}, []string{"includepkgs=kernel* bash"}},
+		{PackageFilter{Exclude: []string{"*-debuginfo"}}, []string{"exclude=*-debuginfo"}},
+		{PackageFilter{Arch: []string{"x86_64"}}, []string{"includepkgs=*.x86_64 *.noarch"}},
+		{PackageFilter{Include: []string{"bash"}, Arch: []string{"x86_64", "aarch64"}, Exclude: []string{"*-devel"}},
+			[]string{"includepkgs=bash.x86_64 bash.aarch64 bash.noarch", "exclude=*-devel"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.filter.repoOptions(), test.expected)
+	}
+}
+
+func TestAppendRepoOptions(t *testing.T) {
+	src := "[baseos]\nbaseurl=https://repo.example.com/8/os/\n"
+
+	assert.Equal(t, appendRepoOptions(src, nil), src)
+	assert.Equal(t, appendRepoOptions(src, []string{"includepkgs=bash"}), src+"includepkgs=bash\n")
+	assert.Equal(t, appendRepoOptions(strings.TrimSpace(src), []string{"throttle=1024"}), src+"throttle=1024\n")
+}
+
+func TestPackageFilterKeep(t *testing.T) {
+	assert.Equal(t, PackageFilter{}.keep(), 0)
+	assert.Equal(t, PackageFilter{KeepVersions: 3}.keep(), 3)
+	assert.Equal(t, PackageFilter{NewestOnly: true, KeepVersions: 3}.keep(), 1)
+}
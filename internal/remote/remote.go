@@ -1,9 +1,34 @@
 package remote
 
+import "context"
+
 const fmtErrPreFlight = "Prerequisite checks and actions failed for '%s' with error: %s"
 
+// Remote synchronizes an upstream repository. The context passed to Sync and
+// Publish carries the logger of the sync job, see zerolog.Ctx.
 type Remote interface {
 	Init() error
-	Sync() error
-	Publish(snapshot string) error
+	Sync(ctx context.Context) (SyncResult, error)
+	Publish(ctx context.Context, snapshot string) error
+}
+
+// SyncResult describes the outcome of a successful sync
+type SyncResult struct {
+	// Changed is true when the sync modified the upstream content
+	Changed      bool
+	FilesAdded   int
+	FilesUpdated int
+	FilesDeleted int
+	// FilesKept is the number of files kept although they were deleted upstream
+	FilesKept        int
+	BytesTransferred int64
+	TotalSize        int64
+	// Mirror is the upstream url the content was synced from
+	Mirror string
+}
+
+// Checker is implemented by remotes which are able to verify that their
+// upstream is reachable before a sync is started.
+type Checker interface {
+	Check(ctx context.Context) error
 }
@@ -1,82 +1,605 @@
 package remote
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// Upper bound for a detached signature, which is usually less than 1KB
+const maxSignatureSize = 64 << 10
+
 type RepoSyncRemote struct {
-	id     string
-	src    string
-	usPath string
-	saPath string
+	id        string
+	src       string
+	mirrors   []string
+	usPath    string
+	saPath    string
+	statePath string
+	filter    PackageFilter
+	http      HTTPOptions
+	flavour   repoSyncFlavour
+	// keepDeleted is the grace period for packages deleted upstream, 0 means
+	// deletions are mirrored
+	keepDeleted time.Duration
+	// signedMetadata requires the signature of the repomd.xml, which is
+	// fetched from the mirror that was synced
+	signedMetadata bool
+}
+
+// repoSyncFlavour is the implementation of reposync installed on the host,
+// yum-utils on EL7 and dnf-plugins-core on EL8 and later
+type repoSyncFlavour int
+
+const (
+	repoSyncYum repoSyncFlavour = iota
+	repoSyncDnf
+)
+
+func (f repoSyncFlavour) String() string {
+	if f == repoSyncDnf {
+		return "dnf"
+	}
+
+	return "yum-utils"
 }
 
-func NewRepoSyncRemote(id string, src string, usPath string, saPath string) *RepoSyncRemote {
+func NewRepoSyncRemote(id string, src string, mirrors []string, usPath string, saPath string, statePath string, filter PackageFilter, http HTTPOptions, keepDeleted time.Duration, signedMetadata bool) *RepoSyncRemote {
 	return &RepoSyncRemote{
-		id:     id,
-		src:    src,
-		usPath: usPath,
-		saPath: saPath,
+		id:        id,
+		src:       src,
+		mirrors:   mirrors,
+		usPath:    usPath,
+		saPath:    saPath,
+		statePath: statePath,
+		filter:    filter,
+		http:      http,
+
+		keepDeleted:    keepDeleted,
+		signedMetadata: signedMetadata,
 	}
 }
 
-func (r RepoSyncRemote) Init() error {
+func (r *RepoSyncRemote) Init() error {
 	if _, err := exec.LookPath("reposync"); err != nil {
 		return errors.Errorf(fmtErrPreFlight, r.id, err)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), detectRepoSyncTimeout)
+	defer cancel()
+
+	flavour, err := detectRepoSync(ctx)
+	if err != nil {
+		return errors.Errorf(fmtErrPreFlight, r.id, err)
+	}
+	r.flavour = flavour
+
+	log.Debug().Str("repo", r.id).Stringer("reposync", r.flavour).Msg("Detected reposync implementation")
+
 	if _, err := exec.LookPath("createrepo"); err != nil {
 		return errors.Errorf(fmtErrPreFlight, r.id, err)
 	}
 
-	// TODO: Path should be configurable RHEL family is using /etc/yum.repos.d/ use github.com/zcalusic/sysinfo to detect distro
-	if err := os.WriteFile(fmt.Sprintf("/etc/yum/repos.d/%s.repo", strings.ToLower(r.id)), []byte(r.src), 0644); err != nil {
+	if _, err := modifyRepoCommand(); err != nil {
+		return errors.Errorf(fmtErrPreFlight, r.id, err)
+	}
+
+	if r.filter.keep() > 0 {
+		if _, err := exec.LookPath("repomanage"); err != nil {
+			return errors.Errorf(fmtErrPreFlight, r.id, err)
+		}
+	}
+
+	if err := r.http.validate(); err != nil {
 		return errors.Errorf(fmtErrPreFlight, r.id, err)
 	}
 
+	if r.signedMetadata && !r.hasSignatureUrl() {
+		return errors.Errorf(fmtErrPreFlight, r.id, "verifying metadata requires a baseurl, mirror or mirrorlist without yum variables to fetch the repomd.xml signature from")
+	}
+
+	if err := r.writeYumConfig(); err != nil {
+		return errors.Errorf(fmtErrPreFlight, r.id, err)
+	}
+
+	if err := r.writeRepoFile("", 0); err != nil {
+		return errors.Errorf(fmtErrPreFlight, r.id, err)
+	}
+
+	// Older versions of Lagoon wrote the repo config to the host
+	legacyPath := fmt.Sprintf("/etc/yum/repos.d/%s.repo", strings.ToLower(r.id))
+	if err := os.Remove(legacyPath); err == nil {
+		log.Info().Str("repo", r.id).Str("path", legacyPath).Msg("Removed legacy repo config")
+	}
+
 	return nil
 }
 
-func (r RepoSyncRemote) Sync() error {
+// detectRepoSyncTimeout bounds reposync --help, which has no sync to cancel it
+const detectRepoSyncTimeout = time.Minute
+
+// detectRepoSync determines the installed reposync implementation from the
+// options listed in its help output
+func detectRepoSync(ctx context.Context) (repoSyncFlavour, error) {
+	out, err := exec.CommandContext(ctx, "reposync", "--help").CombinedOutput()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to run reposync --help")
+	}
+
+	return parseRepoSyncHelp(string(out))
+}
+
+func parseRepoSyncHelp(help string) (repoSyncFlavour, error) {
+	switch {
+	case strings.Contains(help, "--download-path"):
+		return repoSyncDnf, nil
+	case strings.Contains(help, "--download_path"):
+		return repoSyncYum, nil
+	default:
+		return 0, errors.New("unknown reposync implementation, neither yum-utils nor dnf")
+	}
+}
+
+// repoSyncArgs returns the reposync arguments for the installed implementation.
+// dnf downloads the comps with the other metadata and does not read the
+// reposdir from the yum config.
+func (r RepoSyncRemote) repoSyncArgs(repoId string) []string {
+	var args []string
+
+	switch r.flavour {
+	case repoSyncDnf:
+		args = []string{
+			fmt.Sprintf("--config=%s", r.yumConfigPath()),
+			fmt.Sprintf("--setopt=reposdir=%s", filepath.Join(getYumPath(r.statePath), "repos.d")),
+			fmt.Sprintf("--repoid=%s", repoId),
+			"--norepopath",
+			fmt.Sprintf("--download-path=%s", r.usPath),
+			"--download-metadata",
+		}
+	default:
+		args = []string{
+			fmt.Sprintf("--config=%s", r.yumConfigPath()),
+			fmt.Sprintf("--repoid=%s", repoId),
+			"--norepopath",
+			fmt.Sprintf("--download_path=%s", r.usPath),
+			"--downloadcomps",
+			"--download-metadata",
+		}
+	}
+
+	if r.filter.NewestOnly {
+		args = append(args, "--newest-only")
+	}
+
+	// Packages deleted upstream are pruned by Lagoon after the grace period
+	if r.keepDeleted == 0 {
+		args = append(args, "--delete")
+	}
+
+	return args
+}
+
+func getYumPath(statePath string) string {
+	return filepath.Join(statePath, "yum")
+}
+
+func (r RepoSyncRemote) yumConfigPath() string {
+	return filepath.Join(getYumPath(r.statePath), "yum.conf")
+}
+
+// writeYumConfig writes a private yum configuration, so reposync does not
+// depend on or modify the configuration of the host
+func (r RepoSyncRemote) writeYumConfig() error {
+	yumPath := getYumPath(r.statePath)
+
+	for _, dir := range []string{"repos.d", "cache", "persist"} {
+		if err := os.MkdirAll(filepath.Join(yumPath, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(r.yumConfigPath(), []byte(yumConfig(yumPath)), 0644)
+}
+
+func yumConfig(yumPath string) string {
+	options := []string{
+		"[main]",
+		"reposdir=" + filepath.Join(yumPath, "repos.d"),
+		"cachedir=" + filepath.Join(yumPath, "cache"),
+		"persistdir=" + filepath.Join(yumPath, "persist"),
+		"logfile=" + filepath.Join(yumPath, "yum.log"),
+		"keepcache=0",
+		"plugins=0",
+	}
+
+	return strings.Join(options, "\n") + "\n"
+}
+
+// RemoveRepoSyncConfig removes the private yum configuration from the state
+// path of a repository which is no longer configured
+func RemoveRepoSyncConfig(statePath string) error {
+	return os.RemoveAll(getYumPath(statePath))
+}
+
+// writeRepoFile writes the yum repo config including the package filter, HTTP
+// options and bandwidth throttle in bytes per second, 0 means unlimited. When
+// baseUrl is set it replaces the upstream urls of the repo config.
+func (r RepoSyncRemote) writeRepoFile(baseUrl string, throttle int64) error {
+	src := r.src
+	if baseUrl != "" {
+		src = replaceBaseUrl(src, baseUrl)
+	}
+
+	options := append(r.filter.repoOptions(), r.http.repoOptions()...)
+	if throttle > 0 {
+		options = append(options, fmt.Sprintf("throttle=%d", throttle))
+	}
+
+	// Credentials must only be readable by the user running Lagoon
+	perm := os.FileMode(0644)
+	if r.http.Credentials.Password != "" {
+		perm = 0600
+	}
+
+	path := filepath.Join(getYumPath(r.statePath), "repos.d", fmt.Sprintf("%s.repo", strings.ToLower(r.id)))
+
+	if err := os.WriteFile(path, []byte(appendRepoOptions(src, options)), perm); err != nil {
+		return err
+	}
+
+	// WriteFile does not change the permissions of an existing file
+	return os.Chmod(path, perm)
+}
+
+// hasSignatureUrl reports whether the repomd.xml signature can be fetched,
+// which needs an upstream url without yum variables
+func (r RepoSyncRemote) hasSignatureUrl() bool {
+	urls := append(getBaseUrls(r.src), r.mirrors...)
+
+	for _, option := range []string{"mirrorlist", "metalink"} {
+		if listUrl, ok := getRepoOption(r.src, option); ok {
+			urls = append(urls, listUrl)
+		}
+	}
+
+	for _, u := range urls {
+		if !strings.Contains(u, "$") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signatureUrls returns the urls without yum variables, or all urls when
+// every url has them
+func signatureUrls(ctx context.Context, urls []string) []string {
+	var usable []string
+
+	for _, u := range urls {
+		if !strings.Contains(u, "$") {
+			usable = append(usable, u)
+		}
+	}
+
+	if len(usable) == 0 {
+		return urls
+	}
+
+	if len(usable) < len(urls) {
+		zerolog.Ctx(ctx).Debug().Strs("mirrors", usable).Msg("Only syncing from mirrors the repomd.xml signature can be fetched from")
+	}
+
+	return usable
+}
+
+// baseUrls returns the upstream urls to try in order: the baseurls from the
+// repo config, the configured mirrors and the mirrors from a mirrorlist or
+// metalink. Without any urls reposync uses the repo config as is.
+func (r RepoSyncRemote) baseUrls(ctx context.Context) []string {
+	urls := append(getBaseUrls(r.src), r.mirrors...)
+
+	for _, option := range []string{"mirrorlist", "metalink"} {
+		listUrl, ok := getRepoOption(r.src, option)
+		if !ok {
+			continue
+		}
+
+		// Variables such as $basearch can only be expanded by yum itself
+		if strings.Contains(listUrl, "$") {
+			zerolog.Ctx(ctx).Debug().Str(option, listUrl).Msg("Leaving mirror selection to reposync")
+
+			continue
+		}
+
+		client, err := r.http.client()
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("")
+
+			continue
+		}
+
+		if mirrors, err := fetchMirrorlist(ctx, client, r.http.Credentials, listUrl); err == nil {
+			urls = append(urls, mirrors...)
+		} else {
+			zerolog.Ctx(ctx).Warn().Err(err).Str(option, listUrl).Msg("Unable to fetch mirrors")
+		}
+	}
+
+	return urls
+}
+
+func (r RepoSyncRemote) Check(ctx context.Context) error {
+	var baseUrls []string
+
+	for _, baseUrl := range r.baseUrls(ctx) {
+		// Variables such as $releasever can only be expanded by yum itself
+		if strings.Contains(baseUrl, "$") {
+			zerolog.Ctx(ctx).Debug().Str("baseurl", baseUrl).Msg("Not checking baseurl with yum variables")
+
+			continue
+		}
+
+		baseUrls = append(baseUrls, baseUrl)
+	}
+
+	if len(baseUrls) == 0 {
+		// Without a baseurl leave it up to reposync
+		zerolog.Ctx(ctx).Debug().Msg("No baseurl found, skipping upstream check")
+
+		return nil
+	}
+
+	var err error
+
+	// The upstream is available when any of the mirrors is
+	for _, baseUrl := range baseUrls {
+		if err = r.checkMirror(ctx, baseUrl); err == nil {
+			return nil
+		}
+
+		zerolog.Ctx(ctx).Debug().Err(err).Str("mirror", baseUrl).Msg("Mirror unavailable")
+	}
+
+	return err
+}
+
+func (r RepoSyncRemote) checkMirror(ctx context.Context, baseUrl string) error {
+	repomdUrl := strings.TrimSuffix(baseUrl, "/") + "/repodata/repomd.xml"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, repomdUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	r.http.Credentials.authorize(req)
+
+	zerolog.Ctx(ctx).Debug().Str("url", repomdUrl).Msg("Checking reposync upstream")
+
+	client, err := r.http.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "reposync upstream check failed")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("reposync upstream check failed with status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (r RepoSyncRemote) Sync(ctx context.Context) (SyncResult, error) {
+	baseUrls := r.baseUrls(ctx)
+
+	// The signature can not be fetched from urls with yum variables
+	if r.signedMetadata {
+		baseUrls = signatureUrls(ctx, baseUrls)
+	}
+
+	if len(baseUrls) == 0 {
+		return r.syncMirror(ctx, "")
+	}
+
+	return syncMirrors(ctx, r.statePath, baseUrls, func(baseUrl string) (SyncResult, error) {
+		return r.syncMirror(ctx, baseUrl)
+	})
+}
+
+func (r RepoSyncRemote) syncMirror(ctx context.Context, baseUrl string) (SyncResult, error) {
 	if repoId, err := getRepoId(r.src); err == nil {
-		cmd := exec.Command("reposync", "--delete", fmt.Sprintf("--repoid=%s", repoId), "--norepopath", fmt.Sprintf("--download_path=%s", r.usPath), "--downloadcomps", "--download-metadata")
+		// The mirror and bandwidth limit may change between syncs
+		if err := r.writeRepoFile(baseUrl, bandwidthLimit(ctx)); err != nil {
+			return SyncResult{}, err
+		}
+
+		repomdPath := filepath.Join(r.usPath, "repodata", "repomd.xml")
+
+		before, err := fileChecksum(repomdPath)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		beforeManifest, err := buildManifest(r.usPath)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		cmd := exec.CommandContext(ctx, "reposync", r.repoSyncArgs(repoId)...)
+
+		zerolog.Ctx(ctx).Debug().Str("command", cmd.String()).Msg("Executing reposync")
+
+		if err := runCommand(ctx, cmd, nil); err != nil {
+			return SyncResult{}, err
+		}
+
+		if err := r.fetchRepomdSignature(ctx, baseUrl); err != nil {
+			return SyncResult{}, err
+		}
 
-		log.Debug().Str("repo", r.id).Str("command", cmd.String()).Msg("Executing reposync")
+		var kept int
+		var pruned []string
 
-		// TODO: Add repomanage to cleanup old packages?
-		return cmd.Run()
+		if r.keepDeleted > 0 {
+			deleted, err := deletedPackages(ctx, r.usPath)
+			if err != nil {
+				return SyncResult{}, err
+			}
+
+			if kept, pruned, err = keepDeleted(ctx, r.statePath, r.usPath, deleted, r.keepDeleted); err != nil {
+				return SyncResult{}, err
+			}
+		}
+
+		// The repomd.xml references the checksums of all other metadata, so
+		// an unchanged repomd.xml means an unchanged repository
+		after, err := fileChecksum(repomdPath)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		afterManifest, err := buildManifest(r.usPath)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		result := diffManifests(beforeManifest, afterManifest)
+		result.Changed = before == "" || before != after || len(pruned) > 0
+		result.FilesKept = kept
+
+		return result, nil
 	} else {
-		return err
+		return SyncResult{}, err
 	}
 }
 
-func (r RepoSyncRemote) Publish(snapshot string) error {
-	var cmd *exec.Cmd
+// fetchRepomdSignature downloads the detached signature of the repomd.xml,
+// which reposync does not keep, so the upstream metadata can be verified. A
+// signature which no longer exists upstream is removed.
+func (r RepoSyncRemote) fetchRepomdSignature(ctx context.Context, baseUrl string) error {
+	if baseUrl == "" {
+		return nil
+	}
+
+	sigPath := filepath.Join(r.usPath, "repodata", "repomd.xml.asc")
+
+	// Variables such as $releasever can only be expanded by yum itself, a
+	// signature of older metadata must not be left behind
+	if strings.Contains(baseUrl, "$") {
+		zerolog.Ctx(ctx).Debug().Str("baseurl", baseUrl).Msg("Not fetching repomd.xml signature from baseurl with yum variables")
+
+		if err := os.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	sigUrl := strings.TrimSuffix(baseUrl, "/") + "/repodata/repomd.xml.asc"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sigUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	r.http.Credentials.authorize(req)
+
+	client, err := r.http.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch repomd.xml signature")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		zerolog.Ctx(ctx).Debug().Str("url", sigUrl).Msg("Upstream metadata is not signed")
+
+		if err := os.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 
+		return nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unable to fetch repomd.xml signature, status %s", resp.Status)
+	}
+
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch repomd.xml signature")
+	}
+
+	return os.WriteFile(sigPath, sig, 0644)
+}
+
+func (r RepoSyncRemote) Publish(ctx context.Context, snapshot string) error {
 	snapPath := filepath.Join(r.saPath, snapshot)
-	compsPath := filepath.Join(snapPath, "comps.xml")
 
-	if _, err := os.Stat(compsPath); err == nil {
-		log.Debug().Str("repo", r.id).Msg("Groupdata found")
+	// Old versions are only removed from the snapshot, removing them from
+	// upstream would make reposync download them again
+	if err := r.pruneOldPackages(ctx, snapPath); err != nil {
+		return err
+	}
 
-		cmd = exec.Command("createrepo", "--update", "-p", "--workers", "2", "-g", compsPath, snapPath)
-	} else {
-		log.Debug().Str("repo", r.id).Msg("Groupdata not found")
+	return createRepo(ctx, snapPath, r.statePath)
+}
+
+func (r RepoSyncRemote) pruneOldPackages(ctx context.Context, snapPath string) error {
+	keep := r.filter.keep()
+	if keep == 0 {
+		return nil
+	}
 
-		cmd = exec.Command("createrepo", "--update", "-p", "--workers", "2", snapPath)
+	var oldPackages []string
+
+	cmd := exec.CommandContext(ctx, "repomanage", "--old", fmt.Sprintf("--keep=%d", keep), snapPath)
+
+	zerolog.Ctx(ctx).Debug().Str("command", cmd.String()).Msg("Executing repomanage")
+
+	err := runCommand(ctx, cmd, func(line string) {
+		if strings.HasSuffix(line, ".rpm") {
+			oldPackages = append(oldPackages, line)
+		}
+	})
+	if err != nil {
+		return err
 	}
 
-	// TODO: Implement errata support
+	for _, p := range oldPackages {
+		// Never remove anything outside of the snapshot
+		if !strings.HasPrefix(filepath.Clean(p), filepath.Clean(snapPath)+string(filepath.Separator)) {
+			continue
+		}
 
-	return cmd.Run()
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+
+	zerolog.Ctx(ctx).Info().Int("removed", len(oldPackages)).Int("keep", keep).Msg("Removed old package versions from snapshot")
+
+	return nil
 }
 
 func getRepoId(src string) (string, error) {
@@ -94,3 +617,40 @@ func getRepoId(src string) (string, error) {
 
 	return "", errors.New("unable to find repoid")
 }
+
+// getRepoOption returns the value of the first occurrence of an option in src
+func getRepoOption(src string, name string) (string, bool) {
+	r, _ := regexp.Compile(`^` + regexp.QuoteMeta(name) + `\s*=\s*(.*)$`) // Match the value of an option
+
+	for _, line := range strings.Split(src, "\n") {
+		m := r.FindStringSubmatch(strings.TrimSpace(line))
+
+		if len(m) == 2 && strings.TrimSpace(m[1]) != "" {
+			return strings.TrimSpace(m[1]), true
+		}
+	}
+
+	return "", false
+}
+
+func getBaseUrls(src string) []string {
+	if baseUrl, ok := getRepoOption(src, "baseurl"); ok {
+		return strings.Fields(baseUrl)
+	}
+
+	return nil
+}
+
+// replaceBaseUrl replaces the upstream urls in src by a single baseurl
+func replaceBaseUrl(src string, baseUrl string) string {
+	r, _ := regexp.Compile(`^(baseurl|mirrorlist|metalink)\s*=`) // Match options which select the upstream
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(src, "\n"), "\n") {
+		if !r.MatchString(strings.TrimSpace(line)) {
+			lines = append(lines, line)
+		}
+	}
+
+	return appendRepoOptions(strings.Join(lines, "\n"), []string{"baseurl=" + baseUrl})
+}
@@ -1,7 +1,13 @@
 package remote
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/go-playground/assert/v2"
 )
@@ -42,3 +48,180 @@ func TestGetRepoId(t *testing.T) {
 		}
 	}
 }
+
+func TestGetBaseUrls(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{"[repoid]", nil},
+		{`[repoid]
+		mirrorlist=https://mirrors.example.com/?repo=os`, nil},
+
+		{`[repoid]
+		baseurl=https://repo.example.com/8/os/`, []string{"https://repo.example.com/8/os/"}},
+		{`[repoid]
+		baseurl = https://repo.example.com/8/os/ https://mirror.example.com/8/os/`, []string{"https://repo.example.com/8/os/", "https://mirror.example.com/8/os/"}},
+	}
+	for _, test := range tests {
+		Equal(t, getBaseUrls(test.input), test.expected)
+	}
+}
+
+func TestReplaceBaseUrl(t *testing.T) {
+	src := `[repoid]
+name=Repo
+mirrorlist=https://mirrors.example.com/?repo=os
+baseurl=https://repo.example.com/8/os/
+gpgcheck=1
+`
+
+	expected := `[repoid]
+name=Repo
+gpgcheck=1
+baseurl=https://mirror.example.com/8/os/
+`
+
+	Equal(t, replaceBaseUrl(src, "https://mirror.example.com/8/os/"), expected)
+}
+
+func TestYumConfig(t *testing.T) {
+	expected := `[main]
+reposdir=/var/lib/lagoon/state/repo1/yum/repos.d
+cachedir=/var/lib/lagoon/state/repo1/yum/cache
+persistdir=/var/lib/lagoon/state/repo1/yum/persist
+logfile=/var/lib/lagoon/state/repo1/yum/yum.log
+keepcache=0
+plugins=0
+`
+
+	Equal(t, yumConfig(getYumPath("/var/lib/lagoon/state/repo1/")), expected)
+}
+
+func TestParseRepoSyncHelp(t *testing.T) {
+	var tests = []struct {
+		help     string
+		flavour  repoSyncFlavour
+		hasError bool
+	}{
+		{"  -p DESTDIR, --download_path=DESTDIR\n                        Path to download packages to", repoSyncYum, false},
+		{"  -p DOWNLOAD_PATH, --download-path DOWNLOAD_PATH\n                        where to store downloaded repositories", repoSyncDnf, false},
+		{"usage: reposync [options]", 0, true},
+	}
+	for i, test := range tests {
+		flavour, err := parseRepoSyncHelp(test.help)
+
+		if test.hasError {
+			if IsEqual(err, nil) {
+				t.Errorf("Test: %d should result in error", i)
+			}
+		} else {
+			Equal(t, err, nil)
+			Equal(t, flavour, test.flavour)
+		}
+	}
+}
+
+func TestRepoSyncArgs(t *testing.T) {
+	var tests = []struct {
+		flavour     repoSyncFlavour
+		newestOnly  bool
+		keepDeleted time.Duration
+		expected    []string
+	}{
+		{repoSyncYum, false, 0, []string{"--config=/state/repo1/yum/yum.conf", "--repoid=repo1", "--norepopath", "--download_path=/upstream/repo1", "--downloadcomps", "--download-metadata", "--delete"}},
+		{repoSyncYum, true, 0, []string{"--config=/state/repo1/yum/yum.conf", "--repoid=repo1", "--norepopath", "--download_path=/upstream/repo1", "--downloadcomps", "--download-metadata", "--newest-only", "--delete"}},
+		{repoSyncYum, false, time.Hour, []string{"--config=/state/repo1/yum/yum.conf", "--repoid=repo1", "--norepopath", "--download_path=/upstream/repo1", "--downloadcomps", "--download-metadata"}},
+		{repoSyncDnf, false, 0, []string{"--config=/state/repo1/yum/yum.conf", "--setopt=reposdir=/state/repo1/yum/repos.d", "--repoid=repo1", "--norepopath", "--download-path=/upstream/repo1", "--download-metadata", "--delete"}},
+		{repoSyncDnf, true, 0, []string{"--config=/state/repo1/yum/yum.conf", "--setopt=reposdir=/state/repo1/yum/repos.d", "--repoid=repo1", "--norepopath", "--download-path=/upstream/repo1", "--download-metadata", "--newest-only", "--delete"}},
+		{repoSyncDnf, true, time.Hour, []string{"--config=/state/repo1/yum/yum.conf", "--setopt=reposdir=/state/repo1/yum/repos.d", "--repoid=repo1", "--norepopath", "--download-path=/upstream/repo1", "--download-metadata", "--newest-only"}},
+	}
+	for _, test := range tests {
+		r := RepoSyncRemote{usPath: "/upstream/repo1", statePath: "/state/repo1", filter: PackageFilter{NewestOnly: test.newestOnly}, flavour: test.flavour, keepDeleted: test.keepDeleted}
+
+		Equal(t, r.repoSyncArgs("repo1"), test.expected)
+	}
+}
+
+func TestRepoSyncCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/7/x86_64/repodata/repomd.xml" {
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	var tests = []struct {
+		baseUrl string
+		valid   bool
+	}{
+		{server.URL + "/7/x86_64/", true},
+		{server.URL + "/8/x86_64/", false},
+		// Yum variables are left to reposync instead of being probed literally
+		{server.URL + "/$releasever/$basearch/", true},
+		{server.URL + "/$releasever/x86_64/ " + server.URL + "/8/x86_64/", false},
+	}
+	for i, test := range tests {
+		r := RepoSyncRemote{src: "[test]\nbaseurl=" + test.baseUrl + "\n"}
+
+		err := r.Check(context.Background())
+		if test.valid && err != nil {
+			t.Errorf("Test: %d should not result in error: %v", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Test: %d should result in error", i)
+		}
+	}
+}
+
+func TestRepoSyncSignatureUrls(t *testing.T) {
+	r := RepoSyncRemote{src: "[test]\nbaseurl=https://mirror.example.com/$releasever/$basearch/\n"}
+	Equal(t, r.hasSignatureUrl(), false)
+
+	r.mirrors = []string{"https://mirror.example.com/7/x86_64/"}
+	Equal(t, r.hasSignatureUrl(), true)
+
+	r = RepoSyncRemote{src: "[test]\nmirrorlist=https://mirrorlist.example.com/?release=7&arch=x86_64\n"}
+	Equal(t, r.hasSignatureUrl(), true)
+
+	urls := []string{"https://mirror.example.com/$releasever/", "https://mirror.example.com/7/"}
+	Equal(t, signatureUrls(context.Background(), urls), []string{"https://mirror.example.com/7/"})
+	Equal(t, signatureUrls(context.Background(), urls[:1]), urls[:1])
+}
+
+func TestFetchRepomdSignature(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+
+		if req.URL.Path != "/7/x86_64/repodata/repomd.xml.asc" {
+			http.NotFound(w, req)
+
+			return
+		}
+
+		w.Write([]byte("signature"))
+	}))
+	defer server.Close()
+
+	r := RepoSyncRemote{usPath: t.TempDir()}
+	sigPath := filepath.Join(r.usPath, "repodata", "repomd.xml.asc")
+
+	if err := os.MkdirAll(filepath.Dir(sigPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	Equal(t, r.fetchRepomdSignature(context.Background(), server.URL+"/7/x86_64/"), nil)
+
+	sig, err := os.ReadFile(sigPath)
+	Equal(t, err, nil)
+	Equal(t, string(sig), "signature")
+
+	// A baseurl with yum variables is not fetched literally
+	Equal(t, r.fetchRepomdSignature(context.Background(), server.URL+"/$releasever/$basearch/"), nil)
+	Equal(t, requests, 1)
+
+	_, err = os.Stat(sigPath)
+	Equal(t, os.IsNotExist(err), true)
+}
@@ -1,71 +1,426 @@
 package remote
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
+// Matches the update type and attributes of an --itemize-changes line, e.g. ">f.st......"
+var rsyncItemizeRegexp = regexp.MustCompile(`^([<>ch.])[fdLDS]([.+ a-zA-Z?]{9}) `)
+
+// Matches a statistic and its value, e.g. "Number of created files: 1,234 (reg: 1,200, dir: 34)"
+var rsyncStatRegexp = regexp.MustCompile(`^([A-Za-z ]+): ([\d,]+)(?: bytes)?(?: \(reg: ([\d,]+))?`)
+
 type RsyncRemote struct {
-	id       string
-	src      string
-	dest     string
-	excludes []string
+	id        string
+	src       string
+	mirrors   []string
+	dest      string
+	saPath    string
+	statePath string
+	filter    RsyncFilter
+	proxy     string
+	creds     Credentials
+	// keepDeleted is the grace period for files deleted upstream, 0 means
+	// deletions are mirrored
+	keepDeleted time.Duration
+	// twoPhase syncs packages before metadata and checks the tree afterwards
+	twoPhase bool
 }
 
-func NewRsyncRemote(id string, src string, dest string, excludes []string) *RsyncRemote {
+func NewRsyncRemote(id string, src string, mirrors []string, dest string, saPath string, statePath string, filter RsyncFilter, proxy string, creds Credentials, keepDeleted time.Duration, twoPhase bool) *RsyncRemote {
 	return &RsyncRemote{
-		id:       id,
-		src:      src,
-		dest:     dest,
-		excludes: excludes,
+		id:        id,
+		src:       src,
+		mirrors:   mirrors,
+		dest:      dest,
+		saPath:    saPath,
+		statePath: statePath,
+		filter:    filter,
+		proxy:     proxy,
+		creds:     creds,
+
+		keepDeleted: keepDeleted,
+		twoPhase:    twoPhase,
 	}
 }
 
+// sources returns the source followed by the fallback mirrors
+func (r RsyncRemote) sources() []string {
+	return append([]string{r.src}, r.mirrors...)
+}
+
 func (r RsyncRemote) Init() error {
 	if _, err := exec.LookPath("rsync"); err != nil {
 		return errors.Errorf(fmtErrPreFlight, r.id, err)
 	}
 
-	if !isRsyncUrl(r.src) {
-		return errors.New("incorrect rsync url")
+	for _, src := range r.sources() {
+		if !isRsyncUrl(src) {
+			return errors.New("incorrect rsync url")
+		}
+
+		if _, err := rsyncUrlWithUser(src, r.creds.Username); err != nil {
+			return errors.Errorf(fmtErrPreFlight, r.id, err)
+		}
+	}
+
+	if r.proxy != "" {
+		if _, err := rsyncProxy(r.proxy); err != nil {
+			return errors.Errorf(fmtErrPreFlight, r.id, err)
+		}
+	}
+
+	// The metadata of repositories with kept packages is regenerated
+	if r.keepDeleted > 0 {
+		if _, err := exec.LookPath("createrepo"); err != nil {
+			return errors.Errorf(fmtErrPreFlight, r.id, err)
+		}
+
+		if _, err := modifyRepoCommand(); err != nil {
+			return errors.Errorf(fmtErrPreFlight, r.id, err)
+		}
 	}
 
 	return nil
 }
 
-func (r RsyncRemote) Sync() error {
-	// TODO: Factor out I/O related code to add unittests
-	if isRsyncUrl(r.src) {
-		// NOTE: Somehow pattern --exclude={'file1.txt','dir1/*','dir2'} or --exclude={file1.txt,dir1/*,dir2} does not work, using separate excludes for now
-		args := []string{"-avSHP", "--delete"}
-		for _, e := range r.excludes {
-			args = append(append(args, "--exclude"), e)
+// command returns an rsync command from src to dest which connects through
+// the proxy and authenticates if configured, secrets are only passed in the
+// environment of the command. Without dest the source is listed.
+func (r RsyncRemote) command(ctx context.Context, args []string, src string, dest string) (*exec.Cmd, error) {
+	src, err := rsyncUrlWithUser(src, r.creds.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, src)
+	if dest != "" {
+		args = append(args, dest)
+	}
+
+	cmd := exec.CommandContext(ctx, "rsync", args...)
+	cmd.Env = os.Environ()
+
+	if r.proxy != "" {
+		proxy, err := rsyncProxy(r.proxy)
+		if err != nil {
+			return nil, err
 		}
-		args = append(append(args, r.src), r.dest)
 
-		cmd := exec.Command("rsync", args...)
+		cmd.Env = append(cmd.Env, "RSYNC_PROXY="+proxy)
+	}
 
-		log.Debug().Str("repo", r.id).Str("command", cmd.String()).Msg("Executing rsync")
+	if r.creds.Password != "" {
+		cmd.Env = append(cmd.Env, "RSYNC_PASSWORD="+r.creds.Password)
+	}
 
-		if err := cmd.Run(); err != nil {
-			log.Error().Stack().Err(err).Str("repo", r.id).Msg("")
+	return cmd, nil
+}
 
-			return err
+func (r RsyncRemote) Check(ctx context.Context) error {
+	var err error
+
+	// The upstream is available when any of the mirrors is
+	for _, src := range r.sources() {
+		if err = r.checkMirror(ctx, src); err == nil {
+			return nil
 		}
 
-		return nil
-	} else {
-		return errors.New("incorrect rsync url")
+		zerolog.Ctx(ctx).Debug().Err(err).Str("mirror", src).Msg("Mirror unavailable")
+	}
+
+	return err
+}
+
+func (r RsyncRemote) checkMirror(ctx context.Context, src string) error {
+	// Listing the top level of the source is cheap and fails fast when the
+	// rsync daemon or module is unavailable
+	cmd, err := r.command(ctx, []string{"--list-only", "--no-motd"}, src, "")
+	if err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("command", cmd.String()).Msg("Checking rsync upstream")
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "rsync upstream check failed")
+	}
+
+	return nil
+}
+
+func (r RsyncRemote) Sync(ctx context.Context) (SyncResult, error) {
+	return syncMirrors(ctx, r.statePath, r.sources(), func(src string) (SyncResult, error) {
+		return r.syncMirror(ctx, src)
+	})
+}
+
+// Folders holding repository metadata, they are excluded from the first phase
+// of a two phase sync
+var rsyncMetadataDirs = []string{"repodata/", "dists/"}
+
+func (r RsyncRemote) syncMirror(ctx context.Context, src string) (SyncResult, error) {
+	if !isRsyncUrl(src) {
+		return SyncResult{}, errors.New("incorrect rsync url")
+	}
+
+	var result SyncResult
+
+	// Packages are synced first so the metadata never references packages
+	// which have not arrived yet
+	if r.twoPhase {
+		packages, err := r.rsync(ctx, src, r.syncArgs(ctx, true))
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		result = packages
+	}
+
+	res, err := r.rsync(ctx, src, r.syncArgs(ctx, false))
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	result.FilesAdded += res.FilesAdded
+	result.FilesUpdated += res.FilesUpdated
+	result.FilesDeleted += res.FilesDeleted
+	result.BytesTransferred += res.BytesTransferred
+	result.TotalSize = res.TotalSize
+	result.Changed = result.Changed || res.Changed
+
+	// An upstream which is updated during the sync yields metadata which does
+	// not match the packages, it is retried later rather than snapshotted
+	if r.twoPhase {
+		if err := checkRepoTree(ctx, r.dest, r.filter); err != nil {
+			return SyncResult{}, errors.Wrap(err, "upstream is inconsistent, it may be updating")
+		}
+	}
+
+	if r.keepDeleted > 0 {
+		deleted, err := r.deletedFiles(ctx, src)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		kept, pruned, err := keepDeleted(ctx, r.statePath, r.dest, deleted, r.keepDeleted)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		result.FilesKept = kept
+		result.FilesDeleted += len(pruned)
+		result.Changed = result.Changed || len(pruned) > 0
+	}
+
+	return result, nil
+}
+
+// syncArgs returns the rsync arguments of a sync. The packages phase of a two
+// phase sync skips the metadata folders and deletes nothing, deletions are
+// left to the second phase which syncs the complete tree.
+func (r RsyncRemote) syncArgs(ctx context.Context, packagesOnly bool) []string {
+	args := []string{"-avSHP", "--itemize-changes", "--stats"}
+	if r.keepDeleted == 0 && !packagesOnly {
+		args = append(args, "--delete")
+	}
+	if limit := bandwidthLimit(ctx); limit > 0 {
+		// rsync expects the limit in units of 1024 bytes
+		args = append(args, fmt.Sprintf("--bwlimit=%d", (limit+1023)/1024))
+	}
+	if dir := linkDest(ctx); dir != "" {
+		args = append(args, "--link-dest="+dir)
 	}
+
+	filter := r.filter
+	if packagesOnly {
+		// The first matching rule wins, so these precede the configured filters
+		for _, dir := range rsyncMetadataDirs {
+			args = append(args, "--exclude", dir)
+		}
+
+		filter.DeleteExcluded = false
+	}
+
+	return append(args, filter.args()...)
 }
 
-func (r RsyncRemote) Publish(snapshot string) error {
+// rsync runs rsync from src to the upstream folder and returns the changes
+func (r RsyncRemote) rsync(ctx context.Context, src string, args []string) (SyncResult, error) {
+	cmd, err := r.command(ctx, args, src, r.dest)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("command", cmd.String()).Msg("Executing rsync")
+
+	var stats rsyncStats
+	changed := false
+
+	// With --link-dest the destination starts out empty, so its folders are
+	// always created and unchanged files are linked silently
+	linked := linkDest(ctx) != ""
+
+	err = runCommand(ctx, cmd, func(line string) {
+		if linked && strings.HasPrefix(line, "cd") {
+			return
+		}
+
+		changed = changed || isRsyncChange(line)
+		stats.parseLine(line)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Stack().Err(err).Msg("")
+
+		return SyncResult{}, err
+	}
+
+	result := stats.syncResult()
+	result.Changed = changed
+
+	// Every transferred file is new to the destination, added and updated
+	// files can not be told apart
+	if linked {
+		result.FilesAdded = int(stats.transferred)
+		result.FilesUpdated = 0
+	}
+
+	return result, nil
+}
+
+// deletedFiles returns the files of the upstream folder which no longer exist
+// at src, as reported by a dry run with --delete
+func (r RsyncRemote) deletedFiles(ctx context.Context, src string) ([]string, error) {
+	args := append([]string{"-a", "--dry-run", "--delete", "--itemize-changes"}, r.filter.args()...)
+
+	cmd, err := r.command(ctx, args, src, r.dest)
+	if err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("command", cmd.String()).Msg("Listing files deleted upstream")
+
+	var deleted []string
+
+	err = runCommand(ctx, cmd, func(line string) {
+		if !strings.HasPrefix(line, "*deleting") {
+			return
+		}
+
+		if f := strings.TrimSpace(strings.TrimPrefix(line, "*deleting")); !strings.HasSuffix(f, "/") {
+			deleted = append(deleted, f)
+		}
+	})
+
+	return deleted, err
+}
+
+func (r RsyncRemote) Publish(ctx context.Context, snapshot string) error {
+	if r.keepDeleted == 0 {
+		return nil
+	}
+
+	kept, err := KeptFiles(r.statePath)
+	if err != nil {
+		return err
+	}
+
+	// Kept packages are only installable when the metadata lists them
+	for _, repoPath := range repoRoots(filepath.Join(r.saPath, snapshot), kept) {
+		zerolog.Ctx(ctx).Info().Str("path", repoPath).Msg("Regenerating metadata to include packages deleted upstream")
+
+		if err := createRepo(ctx, repoPath, r.statePath); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func isRsyncUrl(src string) bool {
 	return strings.HasPrefix(src, "rsync://")
 }
+
+func isRsyncChange(line string) bool {
+	if strings.HasPrefix(line, "*deleting") {
+		return true
+	}
+
+	m := rsyncItemizeRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+
+	// Items with a dot update type are only changes when attributes differ
+	return m[1] != "." || strings.Trim(m[2], ". ") != ""
+}
+
+// rsyncStats collects the statistics printed by rsync --stats
+type rsyncStats struct {
+	created       int64
+	createdReg    int64
+	deleted       int64
+	transferred   int64
+	totalSize     int64
+	bytesReceived int64
+}
+
+func (s *rsyncStats) parseLine(line string) {
+	m := rsyncStatRegexp.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return
+	}
+
+	switch m[1] {
+	case "Number of created files":
+		s.created = parseRsyncNumber(m[2])
+		s.createdReg = parseRsyncNumber(m[3])
+	case "Number of deleted files":
+		s.deleted = parseRsyncNumber(m[2])
+	case "Number of regular files transferred":
+		s.transferred = parseRsyncNumber(m[2])
+	case "Total file size":
+		s.totalSize = parseRsyncNumber(m[2])
+	case "Total bytes received":
+		s.bytesReceived = parseRsyncNumber(m[2])
+	}
+}
+
+func (s rsyncStats) syncResult() SyncResult {
+	result := SyncResult{
+		FilesAdded:       int(s.created),
+		FilesDeleted:     int(s.deleted),
+		BytesTransferred: s.bytesReceived,
+		TotalSize:        s.totalSize,
+	}
+
+	// Without a breakdown all created files are regular files
+	createdReg := s.createdReg
+	if createdReg == 0 {
+		createdReg = s.created
+	}
+
+	// Transferred files include the newly created ones
+	if s.transferred > createdReg {
+		result.FilesUpdated = int(s.transferred - createdReg)
+	}
+
+	return result
+}
+
+func parseRsyncNumber(s string) int64 {
+	n, _ := strconv.ParseInt(strings.ReplaceAll(s, ",", ""), 10, 64)
+
+	return n
+}
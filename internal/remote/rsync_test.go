@@ -1,7 +1,12 @@
 package remote
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
 )
 
 func TestIsRsyncUrl(t *testing.T) {
@@ -19,3 +24,88 @@ func TestIsRsyncUrl(t *testing.T) {
 		}
 	}
 }
+
+func TestIsRsyncChange(t *testing.T) {
+	var tests = []struct {
+		input   string
+		changed bool
+	}{
+		{"", false},
+		{"receiving incremental file list", false},
+		{"sent 20 bytes  received 1,024 bytes", false},
+		{"total size is 2,048  speedup is 2.00", false},
+		{".d          ./", false},
+		{">f+++++++++ Packages/foo-1.0.rpm", true},
+		{".d..t...... repodata/", true},
+		{"cd+++++++++ Packages/", true},
+		{"*deleting   Packages/foo-0.9.rpm", true},
+	}
+	for i, test := range tests {
+		if test.changed != isRsyncChange(test.input) {
+			t.Errorf("Test: %d expected changed to be %v", i, test.changed)
+		}
+	}
+}
+
+func TestParseRsyncStats(t *testing.T) {
+	output := `receiving incremental file list
+>f+++++++++ Packages/foo-1.0.rpm
+
+Number of files: 1,234 (reg: 1,000, dir: 234)
+Number of created files: 12 (reg: 10, dir: 2)
+Number of deleted files: 3 (reg: 3)
+Number of regular files transferred: 15
+Total file size: 12,345,678 bytes
+Total transferred file size: 1,234,567 bytes
+Literal data: 1,234,567 bytes
+Matched data: 0 bytes
+File list size: 65,536
+Total bytes sent: 1,024
+Total bytes received: 1,240,000
+
+sent 1,024 bytes  received 1,240,000 bytes  82,734.93 bytes/sec
+total size is 12,345,678  speedup is 9.95
+`
+
+	var stats rsyncStats
+	for _, line := range strings.Split(output, "\n") {
+		stats.parseLine(line)
+	}
+
+	result := stats.syncResult()
+
+	if result.FilesAdded != 12 || result.FilesUpdated != 5 || result.FilesDeleted != 3 {
+		t.Errorf("Unexpected file counts: %+v", result)
+	}
+
+	if result.BytesTransferred != 1240000 || result.TotalSize != 12345678 {
+		t.Errorf("Unexpected sizes: %+v", result)
+	}
+}
+
+func TestRsyncSyncArgs(t *testing.T) {
+	var tests = []struct {
+		remote       RsyncRemote
+		packagesOnly bool
+		args         []string
+	}{
+		{RsyncRemote{}, false, []string{"-avSHP", "--itemize-changes", "--stats", "--delete"}},
+		{RsyncRemote{keepDeleted: time.Hour}, false, []string{"-avSHP", "--itemize-changes", "--stats"}},
+		{
+			RsyncRemote{filter: RsyncFilter{Filters: []string{"exclude *.iso"}, DeleteExcluded: true}},
+			false,
+			[]string{"-avSHP", "--itemize-changes", "--stats", "--delete", "--filter", "exclude *.iso", "--delete-excluded"},
+		},
+		{
+			RsyncRemote{filter: RsyncFilter{Filters: []string{"exclude *.iso"}, DeleteExcluded: true}},
+			true,
+			[]string{"-avSHP", "--itemize-changes", "--stats", "--exclude", "repodata/", "--exclude", "dists/", "--filter", "exclude *.iso"},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.remote.syncArgs(context.Background(), test.packagesOnly), test.args)
+	}
+
+	ctx := WithLinkDest(context.Background(), "/var/lib/lagoon/staging/repo1/20220101")
+	assert.Equal(t, RsyncRemote{}.syncArgs(ctx, false), []string{"-avSHP", "--itemize-changes", "--stats", "--delete", "--link-dest=/var/lib/lagoon/staging/repo1/20220101"})
+}
@@ -0,0 +1,177 @@
+package remote
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RsyncFilter limits which files of an rsync upstream are mirrored
+type RsyncFilter struct {
+	Exclude []string
+	// Filters contains rsync filter rules, which are applied in order after
+	// the excludes
+	Filters []string
+	// DeleteExcluded removes files matching an exclude rule which were
+	// mirrored before
+	DeleteExcluded bool
+}
+
+func (f RsyncFilter) args() []string {
+	var args []string
+
+	// NOTE: Somehow pattern --exclude={'file1.txt','dir1/*','dir2'} or --exclude={file1.txt,dir1/*,dir2} does not work, using separate excludes for now
+	for _, e := range f.Exclude {
+		args = append(args, "--exclude", e)
+	}
+
+	for _, rule := range f.Filters {
+		args = append(args, "--filter", rule)
+	}
+
+	if f.DeleteExcluded {
+		args = append(args, "--delete-excluded")
+	}
+
+	return args
+}
+
+// filterRule is an include or exclude rule of a filter as rsync applies it
+type filterRule struct {
+	exclude bool
+	negate  bool
+	dirOnly bool
+	pattern *regexp.Regexp
+}
+
+// rules returns the include and exclude rules of the filter in the order rsync
+// applies them. Merge rules read their rules when rsync runs and are left out,
+// as are the receiver side protect and risk rules.
+func (f RsyncFilter) rules() []filterRule {
+	var rules []filterRule
+
+	for _, e := range f.Exclude {
+		rules = append(rules, newFilterRule(true, "", e))
+	}
+
+	for _, rule := range f.Filters {
+		head, pattern, _ := strings.Cut(rule, " ")
+		name, modifiers, _ := strings.Cut(head, ",")
+
+		switch {
+		case name == "clear" || name == "!":
+			rules = nil
+		case name == "include" || name == "show":
+			rules = append(rules, newFilterRule(false, modifiers, pattern))
+		case name == "exclude" || name == "hide":
+			rules = append(rules, newFilterRule(true, modifiers, pattern))
+		case strings.HasPrefix(name, "+") || strings.HasPrefix(name, "S"):
+			rules = append(rules, newFilterRule(false, head[1:], pattern))
+		case strings.HasPrefix(name, "-") || strings.HasPrefix(name, "H"):
+			rules = append(rules, newFilterRule(true, head[1:], pattern))
+		}
+	}
+
+	return rules
+}
+
+// newFilterRule translates an rsync pattern into a regular expression matching
+// the path relative to the transfer root, see INCLUDE/EXCLUDE PATTERN RULES in
+// rsync(1)
+func newFilterRule(exclude bool, modifiers string, pattern string) filterRule {
+	rule := filterRule{exclude: exclude, negate: strings.Contains(modifiers, "!")}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	// dir/*** matches the folder and everything below it
+	contents := strings.HasSuffix(pattern, "/***")
+	pattern = strings.TrimSuffix(pattern, "/***")
+
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expr strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			if end := strings.IndexByte(pattern[i+1:], ']'); end > 0 {
+				class := pattern[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + class + "]")
+				i += end + 1
+			} else {
+				expr.WriteString(regexp.QuoteMeta("["))
+			}
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	prefix := "(^|/)"
+	if anchored {
+		prefix = "^"
+	}
+
+	suffix := "$"
+	if contents {
+		suffix = "(/.*)?$"
+	}
+
+	rule.pattern = regexp.MustCompile(prefix + expr.String() + suffix)
+
+	return rule
+}
+
+func (r filterRule) matches(path string, isDir bool) bool {
+	matched := (isDir || !r.dirOnly) && r.pattern.MatchString(path)
+
+	return matched != r.negate
+}
+
+// excludes reports whether rsync skips the file at path, relative to the
+// transfer root, because the file or one of its folders is excluded
+func (f RsyncFilter) excludes(path string) bool {
+	rules := f.rules()
+	if len(rules) == 0 {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(path), "/")
+
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+
+		// The first matching rule wins
+		for _, rule := range rules {
+			if rule.matches(prefix, i < len(parts)-1) {
+				if rule.exclude {
+					return true
+				}
+
+				break
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package remote
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestRsyncFilterArgs(t *testing.T) {
+	var tests = []struct {
+		filter   RsyncFilter
+		expected []string
+	}{
+		{RsyncFilter{}, nil},
+		{RsyncFilter{Exclude: []string{"isos/", "debug/"}}, []string{"--exclude", "isos/", "--exclude", "debug/"}},
+		{RsyncFilter{Filters: []string{"include /7/", "include /7/os/***", "exclude *"}, DeleteExcluded: true},
+			[]string{"--filter", "include /7/", "--filter", "include /7/os/***", "--filter", "exclude *", "--delete-excluded"}},
+		{RsyncFilter{Exclude: []string{"isos/"}, Filters: []string{"P /local/"}},
+			[]string{"--exclude", "isos/", "--filter", "P /local/"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.filter.args(), test.expected)
+	}
+}
+
+func TestRsyncFilterExcludes(t *testing.T) {
+	var tests = []struct {
+		filter   RsyncFilter
+		path     string
+		expected bool
+	}{
+		{RsyncFilter{}, "7/os/Packages/bash.rpm", false},
+		{RsyncFilter{Exclude: []string{"isos/"}}, "7/isos/x86_64/CentOS-7.iso", true},
+		{RsyncFilter{Exclude: []string{"isos/"}}, "7/os/isos", false},
+		{RsyncFilter{Exclude: []string{"/isos/"}}, "7/isos/x86_64/CentOS-7.iso", false},
+		{RsyncFilter{Exclude: []string{"*.iso"}}, "7/isos/x86_64/CentOS-7.iso", true},
+		{RsyncFilter{Exclude: []string{"x86_64/*.iso"}}, "7/isos/x86_64/CentOS-7.iso", true},
+		{RsyncFilter{Exclude: []string{"7/**.iso"}}, "7/isos/x86_64/CentOS-7.iso", true},
+		{RsyncFilter{Exclude: []string{"bash-?.rpm"}}, "7/os/Packages/bash-5.rpm", true},
+		{RsyncFilter{Exclude: []string{"bash-[0-4].rpm"}}, "7/os/Packages/bash-5.rpm", false},
+		{RsyncFilter{Filters: []string{"include /7/", "include /7/os/***", "exclude *"}}, "7/os/Packages/bash.rpm", false},
+		{RsyncFilter{Filters: []string{"include /7/", "include /7/os/***", "exclude *"}}, "7/updates/Packages/bash.rpm", true},
+		{RsyncFilter{Filters: []string{"+ /7/", "+ /7/os/***", "- *"}}, "8/os/Packages/bash.rpm", true},
+		{RsyncFilter{Filters: []string{"-! */", "+ *"}}, "7/os/Packages/bash.rpm", true},
+		{RsyncFilter{Exclude: []string{"*.iso"}, Filters: []string{"clear"}}, "CentOS-7.iso", false},
+		{RsyncFilter{Filters: []string{"P /local/", ". /etc/lagoon/centos.rules"}}, "local/bash.rpm", false},
+	}
+	for i, test := range tests {
+		if excluded := test.filter.excludes(test.path); excluded != test.expected {
+			t.Errorf("Test: %d expected %s excluded to be %v", i, test.path, test.expected)
+		}
+	}
+}
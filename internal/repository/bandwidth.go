@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+const fmtClockLayout = "15:04"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Matches a rate with an optional unit, e.g. 512, 20mbit, 5MB or 1.5 GB/s
+var rateRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmg]?)(bit|b)?(?:/s)?$`)
+
+type BandwidthConfig struct {
+	// Limit applies outside of the scheduled windows, empty means unlimited
+	Limit    string            `yaml:"limit" validate:"bw_rate"`
+	Schedule []BandwidthWindow `yaml:"schedule" validate:"dive"`
+}
+
+// BandwidthWindow overrides the limit between From and To, a window with To
+// before From wraps around midnight
+type BandwidthWindow struct {
+	From  string   `yaml:"from" validate:"bw_clock"`
+	To    string   `yaml:"to" validate:"bw_clock"`
+	Days  []string `yaml:"days" validate:"dive,oneof=sun mon tue wed thu fri sat"`
+	Limit string   `yaml:"limit" validate:"bw_rate"`
+}
+
+// LimitAt returns the limit in bytes per second at time t, or 0 when unlimited
+func (b BandwidthConfig) LimitAt(t time.Time) (int64, error) {
+	for _, w := range b.Schedule {
+		if active, err := w.activeAt(t); err != nil {
+			return 0, err
+		} else if active {
+			return ParseRate(w.Limit)
+		}
+	}
+
+	return ParseRate(b.Limit)
+}
+
+func (w BandwidthWindow) activeAt(t time.Time) (bool, error) {
+	if len(w.Days) > 0 {
+		found := false
+
+		for _, d := range w.Days {
+			if weekdays[d] == t.Weekday() {
+				found = true
+			}
+		}
+
+		if !found {
+			return false, nil
+		}
+	}
+
+	from, err := time.Parse(fmtClockLayout, w.From)
+	if err != nil {
+		return false, err
+	}
+
+	to, err := time.Parse(fmtClockLayout, w.To)
+	if err != nil {
+		return false, err
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	start := from.Hour()*60 + from.Minute()
+	end := to.Hour()*60 + to.Minute()
+
+	if start <= end {
+		return now >= start && now < end, nil
+	}
+
+	return now >= start || now < end, nil
+}
+
+// ParseRate converts a rate to bytes per second. Units ending with bit are
+// decimal bits, units ending with B are binary bytes and a rate without a
+// unit is in KB like rsync's --bwlimit. An empty rate or 0 means unlimited.
+func ParseRate(rate string) (int64, error) {
+	if rate == "" {
+		return 0, nil
+	}
+
+	m := rateRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(rate)))
+	if m == nil {
+		return 0, errors.Errorf("invalid rate '%s'", rate)
+	}
+
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if m[3] == "" {
+		if m[2] != "" {
+			return 0, errors.Errorf("invalid rate '%s', missing unit", rate)
+		}
+
+		return int64(value * 1024), nil
+	}
+
+	exp := 0
+	if m[2] != "" {
+		exp = strings.Index("kmg", m[2]) + 1
+	}
+
+	if m[3] == "bit" {
+		for i := 0; i < exp; i++ {
+			value *= 1000
+		}
+
+		return int64(value / 8), nil
+	}
+
+	for i := 0; i < exp; i++ {
+		value *= 1024
+	}
+
+	return int64(value), nil
+}
+
+// minRate returns the strictest of two limits where 0 means unlimited
+func minRate(a int64, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+func ValidateRate(fl validator.FieldLevel) bool {
+	_, err := ParseRate(fl.Field().String())
+
+	return err == nil
+}
+
+func ValidateClock(fl validator.FieldLevel) bool {
+	_, err := time.Parse(fmtClockLayout, fl.Field().String())
+
+	return err == nil
+}
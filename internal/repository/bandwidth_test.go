@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+)
+
+func TestParseRate(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected int64
+		valid    bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{"512", 512 * 1024, true},
+		{"20mbit", 2500000, true},
+		{"20 Mbit/s", 2500000, true},
+		{"800bit", 100, true},
+		{"5MB", 5 * 1024 * 1024, true},
+		{"100B", 100, true},
+		{"1.5 GB/s", 1536 * 1024 * 1024, true},
+		{"fast", 0, false},
+		{"20m", 0, false},
+		{"-1", 0, false},
+	}
+	for i, test := range tests {
+		rate, err := ParseRate(test.input)
+
+		if test.valid {
+			if err != nil {
+				t.Errorf("Test: %d with valid input should not result in error: %s", i, err)
+			}
+			if rate != test.expected {
+				t.Errorf("Test: %d expected %d, got %d", i, test.expected, rate)
+			}
+		} else if err == nil {
+			t.Errorf("Test: %d with invalid input should result in error", i)
+		}
+	}
+}
+
+func TestBandwidthLimitAt(t *testing.T) {
+	bw := BandwidthConfig{
+		Limit: "100mbit",
+		Schedule: []BandwidthWindow{
+			{From: "22:00", To: "06:00", Limit: "0"},
+			{From: "08:00", To: "18:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Limit: "20mbit"},
+		},
+	}
+
+	var tests = []struct {
+		time     time.Time
+		expected int64
+	}{
+		{time.Date(2022, 5, 2, 23, 0, 0, 0, time.UTC), 0},        // Monday night
+		{time.Date(2022, 5, 2, 5, 59, 0, 0, time.UTC), 0},        // Monday early morning
+		{time.Date(2022, 5, 2, 9, 0, 0, 0, time.UTC), 2500000},   // Monday office hours
+		{time.Date(2022, 5, 2, 18, 0, 0, 0, time.UTC), 12500000}, // Monday evening
+		{time.Date(2022, 5, 1, 9, 0, 0, 0, time.UTC), 12500000},  // Sunday
+	}
+	for i, test := range tests {
+		limit, err := bw.LimitAt(test.time)
+
+		assert.Equal(t, err, nil)
+		if limit != test.expected {
+			t.Errorf("Test: %d expected %d, got %d", i, test.expected, limit)
+		}
+	}
+}
+
+func TestMinRate(t *testing.T) {
+	assert.Equal(t, minRate(0, 0), int64(0))
+	assert.Equal(t, minRate(0, 10), int64(10))
+	assert.Equal(t, minRate(10, 0), int64(10))
+	assert.Equal(t, minRate(10, 5), int64(5))
+}
+
+func TestCurrentBandwidthLimit(t *testing.T) {
+	cfg := RepoConfig{Id: "bandwidth1", Bandwidth: BandwidthConfig{Limit: "512"}}
+
+	m := Repo{config: cfg, metrics: newRepoMetrics(cfg)}
+
+	// The limit at the start of the sync no longer applies
+	limit := m.currentBandwidthLimit(zerolog.Nop(), 0)
+
+	assert.Equal(t, limit(), int64(512*1024))
+	assert.Equal(t, testutil.ToFloat64(m.metrics.BandwidthLimit), float64(512*1024))
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// The promotions of the channels of a repo are recorded in its state folder
+const channelsFile = "channels.json"
+
+// Promotion records that a channel was moved to a snapshot
+type Promotion struct {
+	Snapshot string    `json:"snapshot"`
+	By       string    `json:"by"`
+	Time     time.Time `json:"time"`
+}
+
+func (m Repo) hasChannel(name string) bool {
+	for _, c := range m.config.Channels {
+		if c == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// publishedSnapshot returns the snapshot latest or a channel points at, or an
+// empty string when it is not published
+func (m Repo) publishedSnapshot(name string) (string, error) {
+	target, err := os.Readlink(filepath.Join(m.pubPath, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return filepath.Base(target), nil
+}
+
+// channelSnapshots returns latest and the channels by the snapshot they point
+// at
+func (m Repo) channelSnapshots() (map[string][]string, error) {
+	snapshots := map[string][]string{}
+
+	for _, c := range append([]string{"latest"}, m.config.Channels...) {
+		snapshot, err := m.publishedSnapshot(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if snapshot != "" {
+			snapshots[snapshot] = append(snapshots[snapshot], c)
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (m Repo) channelsPath() string {
+	return filepath.Join(m.statePath, channelsFile)
+}
+
+// promotions returns the promotions of each channel, oldest first
+func (m Repo) promotions() (map[string][]Promotion, error) {
+	promotions := map[string][]Promotion{}
+
+	data, err := os.ReadFile(m.channelsPath())
+	if os.IsNotExist(err) {
+		return promotions, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &promotions); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", m.channelsPath())
+	}
+
+	return promotions, nil
+}
+
+func (m Repo) savePromotions(promotions map[string][]Promotion) error {
+	data, err := json.MarshalIndent(promotions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(m.channelsPath(), data)
+}
+
+// resolveSnapshot returns the snapshot a snapshot id, latest or a channel
+// refers to
+func (m Repo) resolveSnapshot(name string) (string, error) {
+	snapshot := name
+
+	if name == "latest" || m.hasChannel(name) {
+		var err error
+		if snapshot, err = m.publishedSnapshot(name); err != nil {
+			return "", err
+		} else if snapshot == "" {
+			return "", errors.Errorf("%s of '%s' is not published", name, m.config.Id)
+		}
+	}
+
+	if !isSnapshotId(snapshot) {
+		return "", errors.Errorf("invalid snapshot id %s", snapshot)
+	}
+
+	if _, err := os.Stat(filepath.Join(m.saPath, snapshot)); err != nil {
+		return "", errors.Errorf("snapshot %s of '%s' not found", snapshot, m.config.Id)
+	}
+
+	return snapshot, nil
+}
+
+// Promote moves channel to snapshot, which may also be latest or another
+// channel, and records who promoted it
+func (m Repo) Promote(channel string, snapshot string, by string) error {
+	if !m.hasChannel(channel) {
+		return errors.Errorf("channel %s of '%s' not configured", channel, m.config.Id)
+	}
+
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if r, err := m.rollback(channel); err != nil {
+		return err
+	} else if r != nil {
+		return errors.Errorf("channel %s of '%s' is rolled back to %s, clear the rollback first", channel, m.config.Id, r.Snapshot)
+	}
+
+	snapshot, err = m.resolveSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceSymlink(filepath.Join(m.saPath, snapshot), filepath.Join(m.pubPath, channel)); err != nil {
+		return err
+	}
+
+	promotions, err := m.promotions()
+	if err != nil {
+		return err
+	}
+
+	promotions[channel] = append(promotions[channel], Promotion{Snapshot: snapshot, By: by, Time: time.Now()})
+
+	if err := m.savePromotions(promotions); err != nil {
+		return err
+	}
+
+	log.Info().Str("repo", m.config.Id).Str("channel", channel).Str("snapshot", snapshot).Str("by", by).Msg("Promoted snapshot")
+
+	return nil
+}
+
+// channelProtections returns a protection for each snapshot latest or a
+// channel points at, so cleanup never breaks them, e.g. after a rollback
+func (m Repo) channelProtections() (map[string]Protection, error) {
+	snapshots, err := m.channelSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	protections := map[string]Protection{}
+
+	for snapshot, channels := range snapshots {
+		sort.Strings(channels)
+
+		protections[snapshot] = Protection{Reason: "published as " + strings.Join(channels, ", ")}
+	}
+
+	return protections, nil
+}
+
+// replaceSymlink points the symlink at path to target, clients never see the
+// symlink missing
+func replaceSymlink(target string, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"-new")
+
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
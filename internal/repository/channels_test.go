@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func newChannelTestRepo(t *testing.T) Repo {
+	dest := t.TempDir()
+
+	m := Repo{
+		config:    RepoConfig{Id: "repo1", Dest: dest, Channels: []string{"dev", "test", "prod"}},
+		saPath:    getStagingPath("repo1", dest),
+		pubPath:   getPublicPath("repo1", dest),
+		statePath: getStatePath("repo1", dest),
+	}
+
+	for _, dir := range []string{m.pubPath, m.statePath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, s := range []string{"20220101", "20220102"} {
+		if err := os.MkdirAll(filepath.Join(m.saPath, s), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(m.saPath, "20220102"), filepath.Join(m.pubPath, "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestPromote(t *testing.T) {
+	m := newChannelTestRepo(t)
+
+	assert.NotEqual(t, m.Promote("staging", "20220101", "alice"), nil)
+	assert.NotEqual(t, m.Promote("prod", "20211231", "alice"), nil)
+	assert.NotEqual(t, m.Promote("prod", "test", "alice"), nil)
+
+	assert.Equal(t, m.Promote("prod", "20220101", "alice"), nil)
+	assert.Equal(t, m.Promote("test", "latest", "bob"), nil)
+	assert.Equal(t, m.Promote("prod", "test", "carol"), nil)
+
+	for channel, snapshot := range map[string]string{"prod": "20220102", "test": "20220102", "dev": ""} {
+		published, err := m.publishedSnapshot(channel)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, published, snapshot)
+	}
+
+	promotions, err := m.promotions()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(promotions["prod"]), 2)
+	assert.Equal(t, promotions["prod"][0].Snapshot, "20220101")
+	assert.Equal(t, promotions["prod"][0].By, "alice")
+	assert.Equal(t, promotions["prod"][1].Snapshot, "20220102")
+	assert.Equal(t, promotions["prod"][1].By, "carol")
+
+	protections, err := m.channelProtections()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(protections), 1)
+	assert.Equal(t, protections["20220102"].Reason, "published as latest, prod, test")
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/klaasjand/lagoon/internal/remote"
+	"github.com/rs/zerolog/log"
+)
+
+// RemoveStaleState removes the generated configuration of repositories which
+// are no longer configured from the state folders of all destinations
+func RemoveStaleState(cfgs []RepoConfig) error {
+	configured := map[string]map[string]bool{}
+
+	for _, cfg := range cfgs {
+		dest := filepath.Clean(cfg.Dest)
+
+		if _, ok := configured[dest]; !ok {
+			configured[dest] = map[string]bool{}
+		}
+
+		configured[dest][cfg.Id] = true
+	}
+
+	for dest, ids := range configured {
+		entries, err := os.ReadDir(filepath.Join(dest, "state"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if !e.IsDir() || ids[e.Name()] {
+				continue
+			}
+
+			log.Info().Str("repo", e.Name()).Msg("Removing generated configuration of repo which is no longer configured")
+
+			if err := remote.RemoveRepoSyncConfig(getStatePath(e.Name(), dest)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+// Matches a number of days or weeks, e.g. 90d or 2w
+var durationDaysRegexp = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// ParseDuration parses a number of days or weeks, e.g. 90d or 2w, or a Go
+// duration such as 36h
+func ParseDuration(s string) (time.Duration, error) {
+	if m := durationDaysRegexp.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit *= 7
+		}
+
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, errors.Errorf("negative duration %s", s)
+	}
+
+	return d, nil
+}
+
+func ValidateDuration(fl validator.FieldLevel) bool {
+	_, err := ParseDuration(fl.Field().String())
+
+	return err == nil
+}
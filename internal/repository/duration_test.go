@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestParseDuration(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"90d", 90 * 24 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"36h", 36 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"0d", 0, true},
+		{"", 0, false},
+		{"d", 0, false},
+		{"1.5d", 0, false},
+		{"-1h", 0, false},
+		{"30 days", 0, false},
+	}
+	for i, test := range tests {
+		d, err := ParseDuration(test.input)
+
+		if test.valid {
+			assert.Equal(t, err, nil)
+			assert.Equal(t, d, test.expected)
+		} else if err == nil {
+			t.Errorf("Test: %d with invalid input should result in error", i)
+		}
+	}
+}
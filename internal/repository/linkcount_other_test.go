@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package repository
+
+import (
+	"io/fs"
+)
+
+// linkCount is unknown, the number of hardlinks is not part of info
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
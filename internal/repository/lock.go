@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// The lock of a repo is held in its state folder
+const lockFile = "lock"
+
+// lock serializes changes to the published snapshots and the state of a repo
+// between the daemon and the commands, which run in separate processes. The
+// returned function releases the lock.
+func (m Repo) lock() (func(), error) {
+	if err := os.MkdirAll(m.statePath, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(m.statePath, lockFile)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFileExclusive(f); err != nil {
+		f.Close()
+
+		return nil, errors.Wrapf(err, "unable to lock %s", path)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package repository
+
+import (
+	"os"
+)
+
+// Without flock the daemon and the commands are not serialized
+func lockFileExclusive(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestLock(t *testing.T) {
+	m := Repo{statePath: t.TempDir()}
+
+	unlock, err := m.lock()
+	assert.Equal(t, err, nil)
+
+	locked := make(chan struct{})
+
+	go func() {
+		unlock, err := m.lock()
+		assert.Equal(t, err, nil)
+
+		close(locked)
+		unlock()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Lock held twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock not acquired after it was released")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package repository
+
+import (
+	"io/fs"
+)
+
+// preserveOwner does nothing, ownership is not preserved on this platform
+func preserveOwner(path string, info fs.FileInfo) error {
+	return nil
+}
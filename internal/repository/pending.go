@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The pending marker in the state folder records that the upstream tree may
+// differ from the latest snapshot. Remotes only report changes since the
+// previous sync, so without it a change is lost when its snapshot is not
+// created, e.g. when a sync fails halfway or verification fails.
+const pendingFile = "pending"
+
+func (m Repo) pendingPath() string {
+	return filepath.Join(m.statePath, pendingFile)
+}
+
+func (m Repo) isPending() bool {
+	_, err := os.Stat(m.pendingPath())
+
+	return err == nil
+}
+
+// markPending is called before a sync starts to modify the upstream tree
+func (m Repo) markPending() error {
+	return os.WriteFile(m.pendingPath(), []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// clearPending is called once the upstream tree is captured in a snapshot, or
+// when a sync changed nothing while no change was pending
+func (m Repo) clearPending() error {
+	if err := os.Remove(m.pendingPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// settlePending returns whether the upstream changed since the latest
+// snapshot after a successful sync, given whether a change was pending before
+// the sync and whether the sync changed anything
+func (m Repo) settlePending(pending bool, changed bool) (bool, error) {
+	// A change of an earlier sync has not been captured in a snapshot yet
+	if pending || changed {
+		return true, nil
+	}
+
+	return false, m.clearPending()
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestSettlePending(t *testing.T) {
+	var tests = []struct {
+		pending bool
+		changed bool
+		result  bool
+	}{
+		{false, false, false},
+		{false, true, true},
+		{true, false, true},
+		{true, true, true},
+	}
+	for i, test := range tests {
+		dest := t.TempDir()
+
+		m := Repo{config: RepoConfig{Id: "repo1", Dest: dest}, statePath: getStatePath("repo1", dest)}
+		if err := os.MkdirAll(m.statePath, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		// Every sync marks a change as pending before it starts
+		assert.Equal(t, m.markPending(), nil)
+
+		changed, err := m.settlePending(test.pending, test.changed)
+		assert.Equal(t, err, nil)
+
+		if changed != test.result {
+			t.Errorf("Test: %d expected changed to be %v", i, test.result)
+		}
+
+		// The marker survives until a snapshot is created
+		assert.Equal(t, m.isPending(), test.result)
+	}
+
+	m := Repo{statePath: t.TempDir()}
+	assert.Equal(t, m.markPending(), nil)
+	assert.Equal(t, m.clearPending(), nil)
+	assert.Equal(t, m.isPending(), false)
+	assert.Equal(t, m.clearPending(), nil)
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// The protected snapshots of a repo are stored next to its snapshots
+const protectedFile = "protected.json"
+
+// Protection keeps a snapshot from being removed by cleanup, e.g. because
+// servers are pinned to it
+type Protection struct {
+	Reason  string     `json:"reason"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (p Protection) active(now time.Time) bool {
+	return p.Expires == nil || now.Before(*p.Expires)
+}
+
+func (m Repo) protectedPath() string {
+	return filepath.Join(m.saPath, protectedFile)
+}
+
+func (m Repo) protections() (map[string]Protection, error) {
+	protections := map[string]Protection{}
+
+	data, err := os.ReadFile(m.protectedPath())
+	if os.IsNotExist(err) {
+		return protections, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &protections); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", m.protectedPath())
+	}
+
+	return protections, nil
+}
+
+func (m Repo) saveProtections(protections map[string]Protection) error {
+	data, err := json.MarshalIndent(protections, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The file is replaced so a running sync never reads a partial file
+	return replaceFile(m.protectedPath(), data)
+}
+
+// Protect keeps snapshot from being removed by cleanup until it is
+// unprotected, or until it expires when expires is not 0
+func (m Repo) Protect(snapshot string, reason string, expires time.Duration) error {
+	if reason == "" {
+		return errors.New("a reason is required to protect a snapshot")
+	}
+
+	if !isSnapshotId(snapshot) {
+		return errors.Errorf("invalid snapshot id %s", snapshot)
+	}
+
+	if _, err := os.Stat(filepath.Join(m.saPath, snapshot)); err != nil {
+		return errors.Errorf("snapshot %s of '%s' not found", snapshot, m.config.Id)
+	}
+
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	protections, err := m.protections()
+	if err != nil {
+		return err
+	}
+
+	p := Protection{Reason: reason, Created: time.Now()}
+	if expires > 0 {
+		t := p.Created.Add(expires)
+		p.Expires = &t
+	}
+
+	protections[snapshot] = p
+
+	if err := m.saveProtections(protections); err != nil {
+		return err
+	}
+
+	log.Info().Str("repo", m.config.Id).Str("snapshot", snapshot).Str("reason", reason).Msg("Protected snapshot")
+
+	return nil
+}
+
+// Unprotect allows snapshot to be removed by cleanup again
+func (m Repo) Unprotect(snapshot string) error {
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	protections, err := m.protections()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := protections[snapshot]; !ok {
+		return errors.Errorf("snapshot %s of '%s' is not protected", snapshot, m.config.Id)
+	}
+
+	delete(protections, snapshot)
+
+	if err := m.saveProtections(protections); err != nil {
+		return err
+	}
+
+	log.Info().Str("repo", m.config.Id).Str("snapshot", snapshot).Msg("Unprotected snapshot")
+
+	return nil
+}
+
+// activeProtections returns the protections which have not expired at now,
+// expired protections and those of removed snapshots are forgotten
+func (m Repo) activeProtections(now time.Time) (map[string]Protection, error) {
+	protections, err := m.protections()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+
+	for snapshot, p := range protections {
+		if _, err := os.Stat(filepath.Join(m.saPath, snapshot)); os.IsNotExist(err) {
+			log.Warn().Str("repo", m.config.Id).Str("snapshot", snapshot).Msg("Protected snapshot no longer exists")
+		} else if !p.active(now) {
+			log.Info().Str("repo", m.config.Id).Str("snapshot", snapshot).Str("reason", p.Reason).Msg("Snapshot protection expired")
+		} else {
+			continue
+		}
+
+		delete(protections, snapshot)
+		changed = true
+	}
+
+	if changed {
+		if err := m.saveProtections(protections); err != nil {
+			return nil, err
+		}
+	}
+
+	return protections, nil
+}
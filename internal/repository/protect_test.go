@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestProtect(t *testing.T) {
+	dest := t.TempDir()
+
+	m := Repo{
+		config:    RepoConfig{Id: "repo1", Dest: dest},
+		saPath:    getStagingPath("repo1", dest),
+		statePath: getStatePath("repo1", dest),
+	}
+
+	for _, s := range []string{"20220101", "20220102", "20220103"} {
+		if err := os.MkdirAll(filepath.Join(m.saPath, s), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.NotEqual(t, m.Protect("20220101", "", 0), nil)
+	assert.NotEqual(t, m.Protect("latest", "pinned", 0), nil)
+	assert.NotEqual(t, m.Protect("20211231", "pinned", 0), nil)
+
+	assert.Equal(t, m.Protect("20220101", "pinned by production", 0), nil)
+	assert.Equal(t, m.Protect("20220102", "audit", 24*time.Hour), nil)
+	assert.Equal(t, m.Protect("20220103", "testing", 0), nil)
+
+	// Protections are read back from disk
+	protected, err := m.activeProtections(time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(protected), 3)
+	assert.Equal(t, protected["20220101"].Reason, "pinned by production")
+	assert.Equal(t, protected["20220101"].Expires == nil, true)
+
+	assert.Equal(t, m.Unprotect("20220103"), nil)
+	assert.NotEqual(t, m.Unprotect("20220103"), nil)
+
+	// Expired protections and those of removed snapshots are forgotten
+	assert.Equal(t, m.Protect("20220103", "testing", 0), nil)
+	assert.Equal(t, os.Remove(filepath.Join(m.saPath, "20220103")), nil)
+
+	protected, err = m.activeProtections(time.Now().Add(48 * time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(protected), 1)
+
+	protections, err := m.protections()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(protections), 1)
+
+	_, ok := protections["20220101"]
+	assert.Equal(t, ok, true)
+}
+
+func TestCleanupSnapshotsProtected(t *testing.T) {
+	m := newChannelTestRepo(t)
+	m.config.Snapshots = 1
+	m.metrics = newRepoMetrics(RepoConfig{Id: "cleanup1"})
+
+	for _, s := range []string{"20220103", "20220104"} {
+		if err := os.MkdirAll(filepath.Join(m.saPath, s), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, replaceSymlink(filepath.Join(m.saPath, "20220104"), filepath.Join(m.pubPath, "latest")), nil)
+
+	assert.Equal(t, m.Rollback("latest", "20220101", "broken bash", "alice"), nil)
+	assert.Equal(t, m.Protect("20220102", "pinned by production", 0), nil)
+
+	assert.Equal(t, m.cleanupSnapshots(), nil)
+
+	// Of the expired snapshots only the one which is neither protected nor
+	// published is removed
+	snapshots, err := m.snapshots()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, snapshots, []string{"20220101", "20220102", "20220104"})
+
+	latest, err := m.publishedSnapshot("latest")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, latest, "20220101")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package repository
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func reflink(in *os.File, out *os.File) error {
+	return errors.New("reflinks are only supported on Linux")
+}
@@ -1,93 +1,157 @@
 package repository
 
 import (
+	"context"
 	"fmt"
-	"io/fs"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/google/uuid"
+	"github.com/klaasjand/lagoon/internal/gpg"
 	"github.com/klaasjand/lagoon/internal/remote"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-const fmtSnapshotLayout = "20060102"
-
-// Matches a date in YYYYMMDD format from 19000101 through 20991231
-const fmtSnapshotPattern = `(19|20)\d\d(0[1-9]|1[012])(0[1-9]|[12][0-9]|3[01])`
-
 const maxSyncRetries = 5
 
+const upstreamCheckTimeout = 30 * time.Second
+
 type RepoMetrics struct {
-	SyncTotal    prometheus.Counter
-	SyncDuration prometheus.Gauge
+	SyncTotal            prometheus.Counter
+	SyncDuration         prometheus.Gauge
+	UpstreamUp           prometheus.Gauge
+	UpstreamUnavailable  prometheus.Counter
+	LastChecked          prometheus.Gauge
+	SyncFilesAdded       prometheus.Gauge
+	SyncFilesUpdated     prometheus.Gauge
+	SyncFilesDeleted     prometheus.Gauge
+	SyncTransferredBytes prometheus.Gauge
+	UpstreamSize         prometheus.Gauge
+	BandwidthLimit       prometheus.Gauge
+	UpstreamKeptFiles    prometheus.Gauge
+	VerifyFailures       prometheus.Counter
+	ProtectedSnapshots   prometheus.Gauge
+	Rollbacks            prometheus.Gauge
 }
 
 type Repo struct {
 	config    RepoConfig
+	global    GlobalConfig
 	waitGroup *sync.WaitGroup
 	isRunning bool
 	metrics   *RepoMetrics
 	usPath    string
 	saPath    string
 	pubPath   string
+	statePath string
 	remote    remote.Remote
+	driver    SnapshotDriver
 }
 
-func NewRepo(cfg RepoConfig, wg *sync.WaitGroup) (*Repo, error) {
-	syncTotal := promauto.NewCounter(prometheus.CounterOpts{
-		Name:        "lagoon_sync_total",
-		Help:        "The total number of repo syncs",
-		ConstLabels: prometheus.Labels{"repo": cfg.Id, "name": cfg.Name},
-	})
+func newRepoMetrics(cfg RepoConfig) *RepoMetrics {
+	labels := prometheus.Labels{"repo": cfg.Id, "name": cfg.Name}
 
-	syncDuration := promauto.NewGauge(prometheus.GaugeOpts{
-		Name:        "lagoon_sync_duration_seconds",
-		Help:        "The sync duration",
-		ConstLabels: prometheus.Labels{"repo": cfg.Id, "name": cfg.Name},
-	})
+	newGauge := func(name string, help string) prometheus.Gauge {
+		return promauto.NewGauge(prometheus.GaugeOpts{Name: name, Help: help, ConstLabels: labels})
+	}
 
-	metrics := &RepoMetrics{SyncTotal: syncTotal, SyncDuration: syncDuration}
+	newCounter := func(name string, help string) prometheus.Counter {
+		return promauto.NewCounter(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: labels})
+	}
+
+	return &RepoMetrics{
+		SyncTotal:            newCounter("lagoon_sync_total", "The total number of repo syncs"),
+		SyncDuration:         newGauge("lagoon_sync_duration_seconds", "The sync duration"),
+		UpstreamUp:           newGauge("lagoon_upstream_up", "Whether the upstream was reachable during the last health check"),
+		UpstreamUnavailable:  newCounter("lagoon_upstream_unavailable_total", "The total number of syncs skipped because the upstream was unavailable"),
+		LastChecked:          newGauge("lagoon_last_checked_timestamp_seconds", "The time of the last successful sync, whether or not the upstream changed"),
+		SyncFilesAdded:       newGauge("lagoon_sync_files_added", "The number of files added by the last sync"),
+		SyncFilesUpdated:     newGauge("lagoon_sync_files_updated", "The number of files updated by the last sync"),
+		SyncFilesDeleted:     newGauge("lagoon_sync_files_deleted", "The number of files deleted by the last sync"),
+		SyncTransferredBytes: newGauge("lagoon_sync_transferred_bytes", "The number of bytes transferred by the last sync"),
+		UpstreamSize:         newGauge("lagoon_upstream_size_bytes", "The total size of the upstream tree after the last sync"),
+		BandwidthLimit:       newGauge("lagoon_bandwidth_limit_bytes", "The bandwidth limit in bytes per second of the current or last sync, 0 means unlimited"),
+		UpstreamKeptFiles:    newGauge("lagoon_upstream_kept_files", "The number of files kept in the upstream tree although they were deleted upstream"),
+		VerifyFailures:       newCounter("lagoon_verify_failures_total", "The total number of snapshots not created because upstream signatures could not be verified"),
+		ProtectedSnapshots:   newGauge("lagoon_protected_snapshots", "The number of snapshots which are protected from cleanup"),
+		Rollbacks:            newGauge("lagoon_rollbacks", "The number of rolled back channels, including latest, which are held until cleared"),
+	}
+}
+
+func NewRepo(cfg RepoConfig, global GlobalConfig, wg *sync.WaitGroup) (*Repo, error) {
+	creds, err := cfg.Credentials.credentials()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read credentials for '%s'", cfg.Id)
+	}
+
+	// rsync and reposync download with their own clients, which can not send
+	// a bearer token
+	if cfg.Credentials.Token.IsSet() && (cfg.Type == "rsync" || cfg.Type == "reposync") {
+		return nil, errors.Errorf("credentials.token of '%s' is not supported by %s remotes, use a username and password", cfg.Id, cfg.Type)
+	}
+
+	if cfg.linkDest() && cfg.Type != "rsync" {
+		return nil, errors.Errorf("snapshot strategy %s of '%s' requires an rsync remote", cfg.SnapshotStrategy, cfg.Id)
+	}
+
+	if cfg.linkDest() && cfg.KeepDeleted != "" {
+		return nil, errors.Errorf("snapshot strategy %s of '%s' can not be combined with keep_deleted", cfg.SnapshotStrategy, cfg.Id)
+	}
+
+	driver, err := newSnapshotDriver(cfg.SnapshotDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := newRepoMetrics(cfg)
 
 	switch cfg.Type {
 	case "dummy":
 		return &Repo{
 			config:    cfg,
+			global:    global,
 			waitGroup: wg,
 			metrics:   metrics,
+			driver:    driver,
 			usPath:    getUpstreamPath(cfg.Id, cfg.Dest),
 			saPath:    getStagingPath(cfg.Id, cfg.Dest),
 			pubPath:   getPublicPath(cfg.Id, cfg.Dest),
+			statePath: getStatePath(cfg.Id, cfg.Dest),
 			remote:    remote.NewDummyRemote(cfg.Id, getUpstreamPath(cfg.Id, cfg.Dest)),
 		}, nil
 	case "rsync":
 		return &Repo{
 			config:    cfg,
+			global:    global,
 			waitGroup: wg,
 			metrics:   metrics,
-			usPath:    getUpstreamPath(cfg.Id, cfg.Dest),
+			driver:    driver,
+			usPath:    cfg.upstreamPath(),
 			saPath:    getStagingPath(cfg.Id, cfg.Dest),
 			pubPath:   getPublicPath(cfg.Id, cfg.Dest),
-			remote:    remote.NewRsyncRemote(cfg.Id, cfg.Src, getUpstreamPath(cfg.Id, cfg.Dest), cfg.Exclude),
+			statePath: getStatePath(cfg.Id, cfg.Dest),
+			remote:    remote.NewRsyncRemote(cfg.Id, cfg.Src, cfg.Mirrors, cfg.upstreamPath(), getStagingPath(cfg.Id, cfg.Dest), getStatePath(cfg.Id, cfg.Dest), cfg.rsyncFilter(), cfg.Proxy, creds, cfg.keepDeleted(), cfg.TwoPhase),
 		}, nil
 	case "reposync":
 		return &Repo{
 			config:    cfg,
+			global:    global,
 			waitGroup: wg,
 			metrics:   metrics,
+			driver:    driver,
 			usPath:    getUpstreamPath(cfg.Id, cfg.Dest),
 			saPath:    getStagingPath(cfg.Id, cfg.Dest),
 			pubPath:   getPublicPath(cfg.Id, cfg.Dest),
-			remote:    remote.NewRepoSyncRemote(cfg.Id, cfg.Src, getUpstreamPath(cfg.Id, cfg.Dest), getStagingPath(cfg.Id, cfg.Dest)),
+			statePath: getStatePath(cfg.Id, cfg.Dest),
+			remote:    remote.NewRepoSyncRemote(cfg.Id, cfg.Src, cfg.Mirrors, getUpstreamPath(cfg.Id, cfg.Dest), getStagingPath(cfg.Id, cfg.Dest), getStatePath(cfg.Id, cfg.Dest), cfg.packageFilter(), cfg.httpOptions(creds), cfg.keepDeleted(), cfg.Verify.Metadata),
 		}, nil
 	default:
 		return nil, fmt.Errorf("unknown repo type '%s'", cfg.Type)
@@ -106,10 +170,21 @@ func getStagingPath(id string, dest string) string {
 	return fmt.Sprintf("%s/staging/%s/", dest, id)
 }
 
+// getIncomingPath returns the folder the next snapshot is synced into with the
+// link_dest snapshot strategy, it is on the same filesystem as the snapshots
+// so it can be moved in place
+func getIncomingPath(id string, dest string) string {
+	return fmt.Sprintf("%s/staging/%s/.incoming/", dest, id)
+}
+
 func getPublicPath(id string, dest string) string {
 	return fmt.Sprintf("%s/public/%s/", dest, id)
 }
 
+func getStatePath(id string, dest string) string {
+	return fmt.Sprintf("%s/state/%s/", dest, id)
+}
+
 func (m Repo) PreReqs() error {
 	if err := os.MkdirAll(m.usPath, 0755); err != nil {
 		return errors.Errorf("destination %s", err)
@@ -123,10 +198,51 @@ func (m Repo) PreReqs() error {
 		return errors.Errorf("destination %s", err)
 	}
 
+	if err := os.MkdirAll(m.statePath, 0755); err != nil {
+		return errors.Errorf("destination %s", err)
+	}
+
+	// With link_dest snapshots are hardlinked by rsync
+	if !m.config.linkDest() {
+		if err := m.driver.Probe(m.usPath, m.saPath); err != nil {
+			return errors.Errorf("snapshot driver %s", err)
+		}
+	}
+
+	if m.config.Verify.enabled() {
+		if _, err := gpg.ReadKeyring(m.config.Verify.Keyrings); err != nil {
+			return errors.Errorf("verify %s", err)
+		}
+	}
+
+	if _, err := m.signingKey(); err != nil {
+		return errors.Errorf("signing %s", err)
+	}
+
 	if err := m.remote.Init(); err != nil {
 		return err
 	}
 
+	unlock, err := m.lock()
+	if err != nil {
+		return errors.Errorf("state %s", err)
+	}
+
+	protected, err := m.activeProtections(time.Now())
+	unlock()
+	if err != nil {
+		return errors.Errorf("protected snapshots %s", err)
+	}
+
+	m.metrics.ProtectedSnapshots.Set(float64(len(protected)))
+
+	rollbacks, err := m.rollbacks()
+	if err != nil {
+		return errors.Errorf("rollbacks %s", err)
+	}
+
+	m.metrics.Rollbacks.Set(float64(len(rollbacks)))
+
 	log.Debug().Msg("All prerequisite checks and actions succeeded")
 
 	return nil
@@ -142,59 +258,242 @@ func (m *Repo) Sync() {
 
 		syncLog.Info().Msg("Starting sync")
 
-		startTime := time.Now()
+		m.runSync(jobId.String(), syncLog)
 
-		// TODO: Add health check before starting sync action
-		syncBackOff := backoff.NewExponentialBackOff()
-		syncBackOff.InitialInterval = 30 * time.Second
-		syncBackOff.MaxInterval = 5 * time.Minute
-		syncBackOff.Multiplier = 1.7
+		syncLog.Info().Msg("Exiting sync")
 
-		notify := func(err error, t time.Duration) {
-			syncLog.Warn().Msgf("Error while synchronizing, retrying in %v", t)
+		m.isRunning = false
+		m.waitGroup.Done()
+	} else {
+		log.Info().Str("repo", m.config.Id).Msg("Not starting sync; sync already in progress")
+	}
+}
+
+func (m *Repo) runSync(jobId string, syncLog zerolog.Logger) {
+	startTime := time.Now()
+
+	// Remotes log with the repo and job id through the logger in the context
+	ctx := syncLog.WithContext(context.Background())
+
+	if err := m.checkUpstream(ctx); err != nil {
+		syncLog.Warn().Err(err).Msg("Upstream unavailable; skipping sync")
+
+		m.metrics.UpstreamUnavailable.Inc()
+
+		return
+	}
+
+	limit, err := m.bandwidthLimit(startTime)
+	if err != nil {
+		syncLog.Error().Stack().Err(err).Msg("Unable to determine bandwidth limit")
+
+		return
+	}
+
+	if limit > 0 {
+		syncLog.Info().Int64("bwlimit", limit).Msg("Limiting bandwidth (bytes per second)")
+	} else {
+		syncLog.Debug().Msg("No bandwidth limit")
+	}
+
+	m.metrics.BandwidthLimit.Set(float64(limit))
+
+	ctx = remote.WithBandwidthLimit(ctx, m.currentBandwidthLimit(syncLog, limit))
+
+	var previous string
+
+	if m.config.linkDest() {
+		if previous, err = m.latestSnapshot(); err != nil {
+			syncLog.Error().Stack().Err(err).Msg("Unable to determine the latest snapshot")
+
+			return
 		}
 
-		err := backoff.RetryNotify(m.remote.Sync, backoff.WithMaxRetries(syncBackOff, maxSyncRetries), notify)
-		if err != nil {
-			syncLog.Error().Stack().Err(err).Msg("Stopped retrying sync")
-		} else {
-			syncLog.Debug().Msg("Successful sync")
+		if previous != "" {
+			ctx = remote.WithLinkDest(ctx, filepath.Join(m.saPath, previous))
+		}
+	}
 
-			if snapshot, err := m.createSnapshot(); err == nil {
-				if err := m.publishSnapshot(snapshot); err != nil {
-					syncLog.Error().Stack().Err(err).Msg("")
-				}
-			} else {
+	pending := m.isPending()
+
+	if err := m.markPending(); err != nil {
+		syncLog.Error().Stack().Err(err).Msg("Unable to mark upstream changes as pending")
+
+		return
+	}
+
+	syncBackOff := backoff.NewExponentialBackOff()
+	syncBackOff.InitialInterval = 30 * time.Second
+	syncBackOff.MaxInterval = 5 * time.Minute
+	syncBackOff.Multiplier = 1.7
+
+	notify := func(err error, t time.Duration) {
+		syncLog.Warn().Msgf("Error while synchronizing, retrying in %v", t)
+	}
+
+	var result remote.SyncResult
+
+	syncRemote := func() (err error) {
+		result, err = m.remote.Sync(ctx)
+
+		return err
+	}
+
+	if err := backoff.RetryNotify(syncRemote, backoff.WithMaxRetries(syncBackOff, maxSyncRetries), notify); err != nil {
+		syncLog.Error().Stack().Err(err).Msg("Stopped retrying sync")
+
+		return
+	}
+
+	if m.config.linkDest() && !result.Changed {
+		result.Changed = m.changedSince(previous)
+	}
+
+	if pending && !result.Changed {
+		syncLog.Info().Msg("Upstream changed since the last snapshot")
+	}
+
+	if result.Changed, err = m.settlePending(pending, result.Changed); err != nil {
+		syncLog.Error().Stack().Err(err).Msg("")
+	}
+
+	syncLog.Info().
+		Str("mirror", result.Mirror).
+		Bool("changed", result.Changed).
+		Int("added", result.FilesAdded).
+		Int("updated", result.FilesUpdated).
+		Int("deleted", result.FilesDeleted).
+		Int("kept", result.FilesKept).
+		Int64("transferred", result.BytesTransferred).
+		Int64("size", result.TotalSize).
+		Msg("Successful sync")
+
+	m.metrics.LastChecked.SetToCurrentTime()
+	m.metrics.SyncFilesAdded.Set(float64(result.FilesAdded))
+	m.metrics.SyncFilesUpdated.Set(float64(result.FilesUpdated))
+	m.metrics.SyncFilesDeleted.Set(float64(result.FilesDeleted))
+	m.metrics.SyncTransferredBytes.Set(float64(result.BytesTransferred))
+	m.metrics.UpstreamSize.Set(float64(result.TotalSize))
+	m.metrics.UpstreamKeptFiles.Set(float64(result.FilesKept))
+
+	if !result.Changed && m.config.SnapshotOnChangeOnly {
+		syncLog.Info().Msg("Upstream has not changed; skipping snapshot")
+	} else if err := m.verifyUpstream(ctx); err != nil {
+		syncLog.Error().Stack().Err(err).Msg("Upstream verification failed; skipping snapshot")
+
+		m.metrics.VerifyFailures.Inc()
+	} else {
+		if snapshot, err := m.createSnapshot(); err == nil {
+			if err := m.clearPending(); err != nil {
+				syncLog.Error().Stack().Err(err).Msg("")
+			}
+
+			if err := m.publishSnapshot(ctx, snapshot); err != nil {
 				syncLog.Error().Stack().Err(err).Msg("")
 			}
 
-			if err := m.cleanupSnapshots(); err != nil {
+			// Publishing may prune packages, so describe the snapshot afterwards
+			if err := m.writeSnapshotMetadata(snapshot, jobId, result); err != nil {
 				syncLog.Error().Stack().Err(err).Msg("")
 			}
+		} else {
+			syncLog.Error().Stack().Err(err).Msg("")
+		}
 
-			endTime := time.Now()
-			m.metrics.SyncDuration.Set(endTime.Sub(startTime).Seconds())
+		if err := m.cleanupSnapshots(); err != nil {
+			syncLog.Error().Stack().Err(err).Msg("")
+		}
+	}
+
+	endTime := time.Now()
+	m.metrics.SyncDuration.Set(endTime.Sub(startTime).Seconds())
+
+	m.metrics.SyncTotal.Inc()
+}
+
+// bandwidthLimit returns the strictest of the repo and global limits at time t
+func (m Repo) bandwidthLimit(t time.Time) (int64, error) {
+	repoLimit, err := m.config.Bandwidth.LimitAt(t)
+	if err != nil {
+		return 0, err
+	}
+
+	globalLimit, err := m.global.Bandwidth.LimitAt(t)
+	if err != nil {
+		return 0, err
+	}
+
+	return minRate(repoLimit, globalLimit), nil
+}
 
-			m.metrics.SyncTotal.Inc()
+// currentBandwidthLimit returns a function which evaluates the bandwidth limit
+// schedules again, so retries, mirrors and the phases of a sync which run into
+// another window of a schedule use its limit. Changes are logged and exported.
+func (m Repo) currentBandwidthLimit(syncLog zerolog.Logger, limit int64) func() int64 {
+	var mu sync.Mutex
+
+	return func() int64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		current, err := m.bandwidthLimit(time.Now())
+		if err != nil {
+			syncLog.Error().Stack().Err(err).Msg("Unable to determine bandwidth limit")
+
+			return limit
 		}
 
-		syncLog.Info().Msg("Exiting sync")
+		if current != limit {
+			syncLog.Info().Int64("bwlimit", current).Msg("Bandwidth limit changed (bytes per second)")
 
-		m.isRunning = false
-		m.waitGroup.Done()
-	} else {
-		log.Info().Str("repo", m.config.Id).Msg("Not starting sync; sync already in progress")
+			m.metrics.BandwidthLimit.Set(float64(current))
+
+			limit = current
+		}
+
+		return limit
 	}
 }
 
+func (m Repo) checkUpstream(ctx context.Context) error {
+	checker, ok := m.remote.(remote.Checker)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamCheckTimeout)
+	defer cancel()
+
+	if err := checker.Check(ctx); err != nil {
+		m.metrics.UpstreamUp.Set(0)
+
+		return err
+	}
+
+	m.metrics.UpstreamUp.Set(1)
+
+	return nil
+}
+
 func (m Repo) createSnapshot() (string, error) {
-	// TODO: Add fs check to preflight checks in order to check if fs supports hardlinks
-	snapshot := time.Now().Format(fmtSnapshotLayout)
+	existing, err := m.snapshots()
+	if err != nil {
+		return "", err
+	}
+
+	snapshot := newSnapshotId(m.config.SnapshotFormat, m.config.SnapshotUTC, time.Now(), existing)
 	snapPath := filepath.Join(m.saPath, snapshot)
 
 	if _, err := os.Stat(snapPath); os.IsNotExist(err) {
-		cmd := exec.Command("cp", "-al", m.usPath, snapPath)
-		if err := cmd.Run(); err != nil {
+		if m.config.linkDest() {
+			// The sync already wrote the snapshot, it only has to be moved in place
+			if err := os.Rename(filepath.Clean(m.usPath), snapPath); err != nil {
+				return "", err
+			}
+		} else if err := m.driver.Snapshot(m.usPath, snapPath); err != nil {
+			// A partial snapshot would block the next attempt
+			os.RemoveAll(snapPath)
+
 			return "", err
 		}
 
@@ -206,21 +505,41 @@ func (m Repo) createSnapshot() (string, error) {
 	return snapshot, nil
 }
 
-func (m Repo) publishSnapshot(snapshot string) error {
+func (m Repo) publishSnapshot(ctx context.Context, snapshot string) error {
 	var err error
 
-	if err = m.remote.Publish(snapshot); err == nil {
+	if err = m.remote.Publish(ctx, snapshot); err == nil {
+		// Signatures must be in place before clients can see the snapshot
+		if err = m.signSnapshot(ctx, snapshot); err != nil {
+			return err
+		}
+
 		snapPath := filepath.Join(m.saPath, snapshot)
 
 		if _, err = os.Stat(snapPath); err == nil {
 			if err = os.Symlink(snapPath, filepath.Join(m.pubPath, snapshot)); err == nil {
 				log.Info().Str("repo", m.config.Id).Str("snapshot", snapPath).Msg("Published snapshot")
-				// New snapshot is published, now publish it as latest
-				if err = m.unPublishSnapshot("latest"); err == nil {
-					log.Info().Str("repo", m.config.Id).Msg("Publishing latest snapshot")
 
-					return os.Symlink(snapPath, filepath.Join(m.pubPath, "latest"))
+				// Rollbacks and promotions of the commands must not interleave
+				unlock, err := m.lock()
+				if err != nil {
+					return err
 				}
+				defer unlock()
+
+				// A rolled back latest stays put until the rollback is cleared
+				if r, err := m.rollback("latest"); err != nil || r != nil {
+					if r != nil {
+						log.Warn().Str("repo", m.config.Id).Str("snapshot", r.Snapshot).Str("reason", r.Reason).Msg("Latest is rolled back; not publishing snapshot as latest")
+					}
+
+					return err
+				}
+
+				// New snapshot is published, now publish it as latest
+				log.Info().Str("repo", m.config.Id).Msg("Publishing latest snapshot")
+
+				return replaceSymlink(snapPath, filepath.Join(m.pubPath, "latest"))
 			}
 		}
 	}
@@ -240,29 +559,115 @@ func (m Repo) unPublishSnapshot(snapshot string) error {
 	return nil
 }
 
+// snapshots returns the staged snapshots, oldest first
+func (m Repo) snapshots() ([]string, error) {
+	fileInfo, err := ioutil.ReadDir(m.saPath)
+	if err != nil {
+		return nil, err
+	}
+
+	allSnapshots := []string{}
+
+	for _, f := range fileInfo {
+		if f.IsDir() && isSnapshotId(f.Name()) {
+			allSnapshots = append(allSnapshots, f.Name())
+		}
+	}
+
+	// Ids of different formats do not sort by name
+	sortSnapshotIds(allSnapshots)
+
+	return allSnapshots, nil
+}
+
+// latestSnapshot returns the newest staged snapshot, or an empty string when
+// there is none
+func (m Repo) latestSnapshot() (string, error) {
+	allSnapshots, err := m.snapshots()
+	if err != nil || len(allSnapshots) == 0 {
+		return "", err
+	}
+
+	return allSnapshots[len(allSnapshots)-1], nil
+}
+
+// changedSince reports whether the synced tree differs from the previous
+// snapshot. With --link-dest rsync does not list deletions, and a retried sync
+// does not list what an earlier attempt transferred, so every file is compared
+// by size and modification time.
+func (m Repo) changedSince(previous string) bool {
+	if previous == "" {
+		return true
+	}
+
+	diff, err := remote.DiffTrees(filepath.Join(m.saPath, previous), m.usPath)
+	if err != nil {
+		// Without a comparison a snapshot is created to be safe
+		log.Warn().Str("repo", m.config.Id).Err(err).Msg("Unable to compare with the previous snapshot")
+
+		return true
+	}
+
+	return diff.Changed
+}
+
+// expiredSnapshots returns the snapshots which are no longer kept at now, by
+// the retention policy or else the number of snapshots to keep
+func (m Repo) expiredSnapshots(allSnapshots []string, now time.Time) []string {
+	if m.config.Retention.enabled() {
+		return m.config.Retention.expired(allSnapshots, snapshotIdClock(now, m.config.SnapshotUTC))
+	}
+
+	if len(allSnapshots) > m.config.Snapshots {
+		return allSnapshots[:len(allSnapshots)-m.config.Snapshots]
+	}
+
+	return nil
+}
+
 func (m Repo) cleanupSnapshots() error {
 	var err error
-	var fileInfo []fs.FileInfo
+	var allSnapshots []string
+
+	// Snapshots protected or promoted by the commands must not be removed
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	if fileInfo, err = ioutil.ReadDir(getStagingPath(m.config.Id, m.config.Dest)); err == nil {
-		// ReadDir already sorts by name, so no need to do it afterwards
-		allSnapshots := []string{}
+	protected, err := m.activeProtections(time.Now())
+	if err != nil {
+		return err
+	}
 
-		r, _ := regexp.Compile(fmtSnapshotPattern)
+	m.metrics.ProtectedSnapshots.Set(float64(len(protected)))
 
-		for _, f := range fileInfo {
-			if f.IsDir() {
-				if r.MatchString(f.Name()) {
-					allSnapshots = append(allSnapshots, f.Name())
-				}
-			}
-		}
+	if rollbacks, err := m.rollbacks(); err == nil {
+		m.metrics.Rollbacks.Set(float64(len(rollbacks)))
+	}
+
+	channels, err := m.channelProtections()
+	if err != nil {
+		return err
+	}
 
-		if len(allSnapshots) > m.config.Snapshots {
-			remSnapshots := allSnapshots[:len(allSnapshots)-m.config.Snapshots]
+	for s, p := range channels {
+		if _, ok := protected[s]; !ok {
+			protected[s] = p
+		}
+	}
 
+	if allSnapshots, err = m.snapshots(); err == nil {
+		if remSnapshots := m.expiredSnapshots(allSnapshots, time.Now()); len(remSnapshots) > 0 {
 			errs := false
 			for _, s := range remSnapshots {
+				if p, ok := protected[s]; ok {
+					log.Info().Str("repo", m.config.Id).Str("snapshot", s).Str("reason", p.Reason).Msg("Keeping protected snapshot")
+
+					continue
+				}
+
 				if err = m.unPublishSnapshot(s); err == nil {
 					snapPath := filepath.Join(m.saPath, s)
 					log.Info().Str("repo", m.config.Id).Str("snapshot", snapPath).Msg("Removing staged snapshot")
@@ -270,6 +675,10 @@ func (m Repo) cleanupSnapshots() error {
 					if err = os.RemoveAll(snapPath); err != nil {
 						errs = true
 					}
+
+					if err = m.removeSnapshotMetadata(s); err != nil {
+						errs = true
+					}
 				} else {
 					break
 				}
@@ -1,9 +1,15 @@
 package repository
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-playground/assert/v2"
+	"github.com/klaasjand/lagoon/internal/secret"
 )
 
 func TestGetUpstreamPath(t *testing.T) {
@@ -14,6 +20,144 @@ func TestGetStagingPath(t *testing.T) {
 	assert.Equal(t, getStagingPath("dummy1", "/var/lib/lagoon"), "/var/lib/lagoon/staging/dummy1/")
 }
 
+func TestGetIncomingPath(t *testing.T) {
+	assert.Equal(t, getIncomingPath("dummy1", "/var/lib/lagoon"), "/var/lib/lagoon/staging/dummy1/.incoming/")
+}
+
 func TestGetPublicPath(t *testing.T) {
 	assert.Equal(t, getPublicPath("dummy1", "/var/lib/lagoon"), "/var/lib/lagoon/public/dummy1/")
 }
+
+func TestGetStatePath(t *testing.T) {
+	assert.Equal(t, getStatePath("dummy1", "/var/lib/lagoon"), "/var/lib/lagoon/state/dummy1/")
+}
+
+func TestRemoveStaleState(t *testing.T) {
+	dest := t.TempDir()
+
+	for _, id := range []string{"repo1", "repo2"} {
+		if err := os.MkdirAll(filepath.Join(getStatePath(id, dest), "yum", "repos.d"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, RemoveStaleState([]RepoConfig{{Id: "repo1", Dest: dest}}), nil)
+
+	_, err := os.Stat(filepath.Join(getStatePath("repo1", dest), "yum"))
+	assert.Equal(t, err, nil)
+
+	_, err = os.Stat(filepath.Join(getStatePath("repo2", dest), "yum"))
+	assert.Equal(t, os.IsNotExist(err), true)
+}
+
+func TestCreateSnapshotLinkDest(t *testing.T) {
+	dest := t.TempDir()
+
+	cfg := RepoConfig{Id: "repo1", Dest: dest, SnapshotStrategy: snapshotStrategyLinkDest}
+	m := Repo{
+		config: cfg,
+		usPath: cfg.upstreamPath(),
+		saPath: getStagingPath("repo1", dest),
+	}
+
+	for _, dir := range []string{m.usPath, filepath.Join(m.saPath, "20220101")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(m.usPath, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := m.latestSnapshot()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, latest, "20220101")
+
+	// The incoming folder is moved in place and is not a snapshot itself
+	snapshot, err := m.createSnapshot()
+	assert.Equal(t, err, nil)
+
+	_, err = os.Stat(filepath.Join(m.saPath, snapshot, "file"))
+	assert.Equal(t, err, nil)
+
+	_, err = os.Stat(m.usPath)
+	assert.Equal(t, os.IsNotExist(err), true)
+
+	snapshots, err := m.snapshots()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, snapshots, []string{"20220101", snapshot})
+}
+
+func TestChangedSince(t *testing.T) {
+	dest := t.TempDir()
+
+	m := Repo{
+		config: RepoConfig{Id: "repo1", Dest: dest},
+		saPath: getStagingPath("repo1", dest),
+		usPath: getIncomingPath("repo1", dest),
+	}
+
+	writeFile := func(path string, content string, modTime time.Time) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modTime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeFile(filepath.Join(m.saPath, "20220101", "a.rpm"), "a", modTime)
+	writeFile(filepath.Join(m.saPath, "20220101", "b.rpm"), "b", modTime)
+
+	var tests = []struct {
+		previous string
+		files    map[string]string
+		modTime  time.Time
+		changed  bool
+	}{
+		{"", map[string]string{"a.rpm": "a", "b.rpm": "b"}, modTime, true},
+		{"20220101", map[string]string{"a.rpm": "a", "b.rpm": "b"}, modTime, false},
+		// Same number of files, one replaced
+		{"20220101", map[string]string{"a.rpm": "a", "c.rpm": "c"}, modTime, true},
+		{"20220101", map[string]string{"a.rpm": "a", "b.rpm": "bb"}, modTime, true},
+		{"20220101", map[string]string{"a.rpm": "a", "b.rpm": "b"}, modTime.Add(time.Hour), true},
+		{"20220101", map[string]string{"a.rpm": "a"}, modTime, true},
+		{"20220102", map[string]string{"a.rpm": "a", "b.rpm": "b"}, modTime, true},
+	}
+	for i, test := range tests {
+		if err := os.RemoveAll(m.usPath); err != nil {
+			t.Fatal(err)
+		}
+
+		for f, content := range test.files {
+			writeFile(filepath.Join(m.usPath, f), content, test.modTime)
+		}
+
+		if changed := m.changedSince(test.previous); changed != test.changed {
+			t.Errorf("Test: %d expected changed to be %v", i, test.changed)
+		}
+	}
+}
+
+func TestNewRepoToken(t *testing.T) {
+	t.Setenv("LAGOON_TEST_TOKEN", "t0ken")
+
+	token := secret.Source{Env: "LAGOON_TEST_TOKEN"}
+
+	for _, repoType := range []string{"rsync", "reposync"} {
+		cfg := RepoConfig{Id: "token-" + repoType, Type: repoType, Dest: t.TempDir(), Credentials: CredentialsConfig{Token: token}}
+
+		_, err := NewRepo(cfg, GlobalConfig{}, &sync.WaitGroup{})
+		if err == nil || !strings.Contains(err.Error(), "credentials.token") {
+			t.Errorf("A token for %s should be rejected, got %v", repoType, err)
+		}
+	}
+}
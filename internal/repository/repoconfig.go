@@ -3,21 +3,137 @@ package repository
 import (
 	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/klaasjand/lagoon/internal/remote"
+	"github.com/klaasjand/lagoon/internal/secret"
+	"github.com/pkg/errors"
 
 	"github.com/robfig/cron/v3"
 )
 
 type RepoConfig struct {
-	Id        string   `yaml:"id" validate:"repo_id"`
-	Name      string   `yaml:"name"`
-	Type      string   `yaml:"type" validate:"oneof=dummy reposync rsync"`
-	Src       string   `yaml:"src"`
-	Dest      string   `yaml:"dest" validate:"repo_path"`
-	Cron      string   `yaml:"cron" validate:"repo_cron"`
-	Exclude   []string `yaml:"exclude"`
-	Snapshots int      `yaml:"snapshots" validate:"min=1,max=1024"`
+	Id                   string            `yaml:"id" validate:"repo_id"`
+	Name                 string            `yaml:"name"`
+	Type                 string            `yaml:"type" validate:"oneof=dummy reposync rsync"`
+	Src                  string            `yaml:"src"`
+	Mirrors              []string          `yaml:"mirrors"`
+	Dest                 string            `yaml:"dest" validate:"repo_path"`
+	Cron                 string            `yaml:"cron" validate:"repo_cron"`
+	Exclude              []string          `yaml:"exclude"`
+	Filters              []string          `yaml:"filters" validate:"dive,rsync_filter"`
+	DeleteExcluded       bool              `yaml:"delete_excluded" mapstructure:"delete_excluded" validate:"excluded_with=KeepDeleted"`
+	KeepDeleted          string            `yaml:"keep_deleted" mapstructure:"keep_deleted" validate:"omitempty,repo_duration"`
+	TwoPhase             bool              `yaml:"two_phase" mapstructure:"two_phase"`
+	Snapshots            int               `yaml:"snapshots" validate:"required_without=Retention,excluded_with=Retention,min=0,max=1024"`
+	Retention            RetentionConfig   `yaml:"retention"`
+	SnapshotOnChangeOnly bool              `yaml:"snapshot_on_change_only" mapstructure:"snapshot_on_change_only"`
+	Channels             []string          `yaml:"channels" validate:"unique,dive,repo_channel"`
+	SnapshotFormat       string            `yaml:"snapshot_format" mapstructure:"snapshot_format" validate:"omitempty,oneof=daily minute second sequence"`
+	SnapshotUTC          bool              `yaml:"snapshot_utc" mapstructure:"snapshot_utc"`
+	SnapshotDriver       string            `yaml:"snapshot_driver" mapstructure:"snapshot_driver" validate:"omitempty,oneof=hardlink reflink copy"`
+	SnapshotStrategy     string            `yaml:"snapshot_strategy" mapstructure:"snapshot_strategy" validate:"omitempty,oneof=upstream link_dest"`
+	Include              []string          `yaml:"include"`
+	Arch                 []string          `yaml:"arch"`
+	NewestOnly           bool              `yaml:"newest_only" mapstructure:"newest_only"`
+	KeepVersions         int               `yaml:"keep_versions" mapstructure:"keep_versions" validate:"min=0"`
+	Bandwidth            BandwidthConfig   `yaml:"bandwidth"`
+	Proxy                string            `yaml:"proxy" validate:"omitempty,url"`
+	CACert               string            `yaml:"ca_cert" mapstructure:"ca_cert" validate:"omitempty,repo_path"`
+	ClientCert           string            `yaml:"client_cert" mapstructure:"client_cert" validate:"omitempty,repo_path"`
+	ClientKey            string            `yaml:"client_key" mapstructure:"client_key" validate:"omitempty,repo_path"`
+	Credentials          CredentialsConfig `yaml:"credentials"`
+	Verify               VerifyConfig      `yaml:"verify"`
+	Signing              SigningConfig     `yaml:"signing"`
+}
+
+// CredentialsConfig holds the credentials for an upstream, secrets are read
+// from a file or environment variable so they never end up in lagoon.yml
+type CredentialsConfig struct {
+	Username string        `yaml:"username"`
+	Password secret.Source `yaml:"password"`
+	Token    secret.Source `yaml:"token"`
+}
+
+func (c CredentialsConfig) credentials() (remote.Credentials, error) {
+	password, err := c.Password.Value()
+	if err != nil {
+		return remote.Credentials{}, errors.Wrap(err, "password")
+	}
+
+	token, err := c.Token.Value()
+	if err != nil {
+		return remote.Credentials{}, errors.Wrap(err, "token")
+	}
+
+	return remote.Credentials{Username: c.Username, Password: password, Token: token}, nil
+}
+
+// GlobalConfig holds the settings which apply to all repositories
+type GlobalConfig struct {
+	Bandwidth BandwidthConfig `yaml:"bandwidth"`
+	Signing   SigningConfig   `yaml:"signing"`
+}
+
+// Snapshot strategies, upstream syncs into the upstream folder which is then
+// hardlinked into a snapshot, link_dest syncs directly into a new snapshot
+const (
+	snapshotStrategyUpstream = "upstream"
+	snapshotStrategyLinkDest = "link_dest"
+)
+
+func (c RepoConfig) linkDest() bool {
+	return c.SnapshotStrategy == snapshotStrategyLinkDest
+}
+
+// upstreamPath returns the folder the remote syncs into
+func (c RepoConfig) upstreamPath() string {
+	if c.linkDest() {
+		return getIncomingPath(c.Id, c.Dest)
+	}
+
+	return getUpstreamPath(c.Id, c.Dest)
+}
+
+func (c RepoConfig) packageFilter() remote.PackageFilter {
+	return remote.PackageFilter{
+		Include:      c.Include,
+		Exclude:      c.Exclude,
+		Arch:         c.Arch,
+		NewestOnly:   c.NewestOnly,
+		KeepVersions: c.KeepVersions,
+	}
+}
+
+// keepDeleted returns the grace period for files deleted upstream, 0 means
+// deletions are mirrored
+func (c RepoConfig) keepDeleted() time.Duration {
+	if c.KeepDeleted == "" {
+		return 0
+	}
+
+	d, _ := ParseDuration(c.KeepDeleted)
+
+	return d
+}
+
+func (c RepoConfig) rsyncFilter() remote.RsyncFilter {
+	return remote.RsyncFilter{
+		Exclude:        c.Exclude,
+		Filters:        c.Filters,
+		DeleteExcluded: c.DeleteExcluded,
+	}
+}
+
+func (c RepoConfig) httpOptions(creds remote.Credentials) remote.HTTPOptions {
+	return remote.HTTPOptions{
+		Proxy:       c.Proxy,
+		CACert:      c.CACert,
+		ClientCert:  c.ClientCert,
+		ClientKey:   c.ClientKey,
+		Credentials: creds,
+	}
 }
 
 func ValidateId(fl validator.FieldLevel) bool {
@@ -31,6 +147,14 @@ func ValidateId(fl validator.FieldLevel) bool {
 	}
 }
 
+// ValidateChannel checks that a channel name is a valid id which can not be
+// confused with latest or a snapshot in the public folder
+func ValidateChannel(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+
+	return ValidateId(fl) && name != "latest" && !isSnapshotId(name)
+}
+
 func ValidatePathAbs(fl validator.FieldLevel) bool {
 	return filepath.IsAbs(fl.Field().String())
 }
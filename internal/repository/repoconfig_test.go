@@ -41,6 +41,33 @@ func TestValidateId(t *testing.T) {
 	}
 }
 
+func TestValidateChannel(t *testing.T) {
+	validate := validator.New()
+	validate.RegisterValidation("repo_channel", ValidateChannel)
+
+	var tests = []struct {
+		input string
+		valid bool
+	}{
+		{"", false},
+		{"prod", true},
+		{"test-2", true},
+		{"Prod", false},
+		{"latest", false},
+		{"20220101", false},
+		{"20220101.001", false},
+	}
+	for i, test := range tests {
+		errs := validate.Var(test.input, "repo_channel")
+
+		if test.valid && !IsEqual(errs, nil) {
+			t.Errorf("Test: %d with valid input should not result in error: %s", i, errs)
+		} else if !test.valid && IsEqual(errs, nil) {
+			t.Errorf("Test: %d with invalid input should result in error", i)
+		}
+	}
+}
+
 func TestValidatePathAbs(t *testing.T) {
 	validate := validator.New()
 	validate.RegisterValidation("repo_path", ValidatePathAbs)
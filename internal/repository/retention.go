@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetentionConfig is a grandfather-father-son retention policy, the newest
+// snapshot of each of the last Daily days, Weekly weeks, Monthly months and
+// Yearly years is kept
+type RetentionConfig struct {
+	Daily   int `yaml:"daily" validate:"min=0"`
+	Weekly  int `yaml:"weekly" validate:"min=0"`
+	Monthly int `yaml:"monthly" validate:"min=0"`
+	Yearly  int `yaml:"yearly" validate:"min=0"`
+	// MaxAge removes snapshots older than this, even when a rule keeps them
+	MaxAge string `yaml:"max_age" mapstructure:"max_age" validate:"omitempty,repo_duration"`
+	// MinCount is the number of newest snapshots which are always kept
+	MinCount int `yaml:"min_count" mapstructure:"min_count" validate:"min=0"`
+}
+
+func (c RetentionConfig) enabled() bool {
+	return c != RetentionConfig{}
+}
+
+// retentionRule keeps the newest snapshot of each of the last count periods
+type retentionRule struct {
+	count  int
+	period func(t time.Time) string
+}
+
+func (c RetentionConfig) rules() []retentionRule {
+	return []retentionRule{
+		{c.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{c.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{c.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{c.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// expired returns the snapshots, sorted oldest first, which are not kept by
+// the policy at now. The newest snapshot is always kept as it is published as
+// latest. now must be on the clock of the snapshot ids, see snapshotIdClock.
+func (c RetentionConfig) expired(snapshots []string, now time.Time) []string {
+	newest := make([]string, len(snapshots))
+	for i, s := range snapshots {
+		newest[len(snapshots)-1-i] = s
+	}
+
+	keep := map[string]bool{}
+	hasRules := false
+
+	for _, rule := range c.rules() {
+		if rule.count == 0 {
+			continue
+		}
+
+		hasRules = true
+		periods := map[string]bool{}
+
+		for _, s := range newest {
+			if len(periods) == rule.count {
+				break
+			}
+
+			t, _ := parseSnapshotId(s)
+
+			if p := rule.period(t); !periods[p] {
+				periods[p] = true
+				keep[s] = true
+			}
+		}
+	}
+
+	maxAge, _ := ParseDuration(c.MaxAge)
+
+	var expired []string
+
+	for i, s := range newest {
+		t, _ := parseSnapshotId(s)
+
+		kept := !hasRules || keep[s]
+		if c.MaxAge != "" && now.Sub(t) > maxAge {
+			kept = false
+		}
+
+		if i == 0 || i < c.MinCount {
+			kept = true
+		}
+
+		if !kept {
+			expired = append([]string{s}, expired...)
+		}
+	}
+
+	return expired
+}
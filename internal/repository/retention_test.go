@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestRetentionExpired(t *testing.T) {
+	// A daily snapshot from 2021-01-01 through 2022-06-30
+	var snapshots []string
+	for d := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC); d.Before(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)); d = d.AddDate(0, 0, 1) {
+		snapshots = append(snapshots, d.Format(fmtSnapshotLayout))
+	}
+
+	now := time.Date(2022, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	kept := func(policy RetentionConfig) []string {
+		expired := map[string]bool{}
+		for _, s := range policy.expired(snapshots, now) {
+			expired[s] = true
+		}
+
+		var kept []string
+		for _, s := range snapshots {
+			if !expired[s] {
+				kept = append(kept, s)
+			}
+		}
+
+		return kept
+	}
+
+	// Two weeks of dailies and a year of monthlies
+	assert.Equal(t, kept(RetentionConfig{Daily: 14, Monthly: 12}), []string{
+		"20210731", "20210831", "20210930", "20211031", "20211130", "20211231",
+		"20220131", "20220228", "20220331", "20220430", "20220531",
+		"20220617", "20220618", "20220619", "20220620", "20220621", "20220622", "20220623",
+		"20220624", "20220625", "20220626", "20220627", "20220628", "20220629", "20220630",
+	})
+
+	// ISO weeks end on Sunday
+	assert.Equal(t, kept(RetentionConfig{Weekly: 3}), []string{"20220619", "20220626", "20220630"})
+	assert.Equal(t, kept(RetentionConfig{Yearly: 5}), []string{"20211231", "20220630"})
+
+	// The maximum age overrides the rules, but not the minimum count
+	assert.Equal(t, kept(RetentionConfig{Monthly: 12, MaxAge: "60d"}), []string{"20220531", "20220630"})
+	assert.Equal(t, kept(RetentionConfig{MaxAge: "2d"}), []string{"20220629", "20220630"})
+	assert.Equal(t, kept(RetentionConfig{MaxAge: "1h", MinCount: 3}), []string{"20220628", "20220629", "20220630"})
+
+	// The newest snapshot is always kept
+	assert.Equal(t, kept(RetentionConfig{MaxAge: "1h"}), []string{"20220630"})
+}
+
+func TestRetentionExpiredSubDaily(t *testing.T) {
+	snapshots := []string{"20220629T2200", "20220629T2300", "20220630.001", "20220630.002", "20220630T1000"}
+
+	expired := RetentionConfig{Daily: 2}.expired(snapshots, time.Date(2022, 6, 30, 12, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, expired, []string{"20220629T2200", "20220630.001", "20220630.002"})
+}
+
+func TestExpiredSnapshots(t *testing.T) {
+	snapshots := []string{"20220101", "20220102", "20220103"}
+
+	m := Repo{config: RepoConfig{Snapshots: 2}}
+	assert.Equal(t, m.expiredSnapshots(snapshots, time.Now()), []string{"20220101"})
+
+	m = Repo{config: RepoConfig{Snapshots: 5}}
+	assert.Equal(t, len(m.expiredSnapshots(snapshots, time.Now())), 0)
+}
+
+func TestSnapshotIdClock(t *testing.T) {
+	now := time.Date(2022, 6, 30, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t, snapshotIdClock(now, true), time.Date(2022, 6, 30, 10, 0, 0, 0, time.UTC))
+}
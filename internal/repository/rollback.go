@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Active rollbacks of a repo are recorded in its state folder
+const rollbacksFile = "rollbacks.json"
+
+// Rollback records that latest or a channel was moved back to a snapshot, it
+// is held there until the rollback is cleared
+type Rollback struct {
+	Snapshot string    `json:"snapshot"`
+	From     string    `json:"from"`
+	Reason   string    `json:"reason,omitempty"`
+	By       string    `json:"by"`
+	Time     time.Time `json:"time"`
+}
+
+func (m Repo) rollbacksPath() string {
+	return filepath.Join(m.statePath, rollbacksFile)
+}
+
+func (m Repo) rollbacks() (map[string]Rollback, error) {
+	rollbacks := map[string]Rollback{}
+
+	data, err := os.ReadFile(m.rollbacksPath())
+	if os.IsNotExist(err) {
+		return rollbacks, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &rollbacks); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", m.rollbacksPath())
+	}
+
+	return rollbacks, nil
+}
+
+func (m Repo) saveRollbacks(rollbacks map[string]Rollback) error {
+	data, err := json.MarshalIndent(rollbacks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFile(m.rollbacksPath(), data); err != nil {
+		return err
+	}
+
+	// Repos used by the CLI commands and tests have no metrics
+	if m.metrics != nil {
+		m.metrics.Rollbacks.Set(float64(len(rollbacks)))
+	}
+
+	return nil
+}
+
+// rollback returns the active rollback of latest or a channel, or nil
+func (m Repo) rollback(name string) (*Rollback, error) {
+	rollbacks, err := m.rollbacks()
+	if err != nil {
+		return nil, err
+	}
+
+	if r, ok := rollbacks[name]; ok {
+		return &r, nil
+	}
+
+	return nil, nil
+}
+
+// previousSnapshot returns the newest snapshot which is older than snapshot
+func (m Repo) previousSnapshot(snapshot string) (string, error) {
+	allSnapshots, err := m.snapshots()
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(allSnapshots) - 1; i >= 0; i-- {
+		if snapshotIdLess(allSnapshots[i], snapshot) {
+			return allSnapshots[i], nil
+		}
+	}
+
+	return "", errors.Errorf("no snapshot of '%s' before %s", m.config.Id, snapshot)
+}
+
+// Rollback moves latest or a channel back to snapshot, or to the snapshot
+// before the current one when snapshot is empty. Until the rollback is cleared
+// new snapshots are not published as latest and the channel is not promoted.
+func (m Repo) Rollback(name string, snapshot string, reason string, by string) error {
+	if name != "latest" && !m.hasChannel(name) {
+		return errors.Errorf("channel %s of '%s' not configured", name, m.config.Id)
+	}
+
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := m.publishedSnapshot(name)
+	if err != nil {
+		return err
+	} else if current == "" {
+		return errors.Errorf("%s of '%s' is not published", name, m.config.Id)
+	}
+
+	if snapshot == "" {
+		snapshot, err = m.previousSnapshot(current)
+	} else {
+		snapshot, err = m.resolveSnapshot(snapshot)
+	}
+	if err != nil {
+		return err
+	}
+
+	rollbacks, err := m.rollbacks()
+	if err != nil {
+		return err
+	}
+
+	// A second rollback keeps the snapshot the first one moved away from
+	from := current
+	if r, ok := rollbacks[name]; ok {
+		from = r.From
+	}
+
+	if err := replaceSymlink(filepath.Join(m.saPath, snapshot), filepath.Join(m.pubPath, name)); err != nil {
+		return err
+	}
+
+	rollbacks[name] = Rollback{Snapshot: snapshot, From: from, Reason: reason, By: by, Time: time.Now()}
+
+	if err := m.saveRollbacks(rollbacks); err != nil {
+		return err
+	}
+
+	log.Warn().
+		Str("repo", m.config.Id).
+		Str("channel", name).
+		Str("snapshot", snapshot).
+		Str("from", current).
+		Str("reason", reason).
+		Str("by", by).
+		Msg("Rolled back snapshot")
+
+	return nil
+}
+
+// ClearRollback allows latest or a channel to move forward again, it stays at
+// the rolled back snapshot until the next publish or promotion
+func (m Repo) ClearRollback(name string) error {
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rollbacks, err := m.rollbacks()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := rollbacks[name]; !ok {
+		return errors.Errorf("%s of '%s' is not rolled back", name, m.config.Id)
+	}
+
+	delete(rollbacks, name)
+
+	if err := m.saveRollbacks(rollbacks); err != nil {
+		return err
+	}
+
+	log.Info().Str("repo", m.config.Id).Str("channel", name).Msg("Cleared rollback")
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/klaasjand/lagoon/internal/remote"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRollback(t *testing.T) {
+	m := newChannelTestRepo(t)
+	m.metrics = newRepoMetrics(RepoConfig{Id: "rollback1"})
+
+	if err := os.MkdirAll(filepath.Join(m.saPath, "20220103"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, replaceSymlink(filepath.Join(m.saPath, "20220103"), filepath.Join(m.pubPath, "latest")), nil)
+
+	assert.NotEqual(t, m.Rollback("staging", "", "", "alice"), nil)
+	assert.NotEqual(t, m.Rollback("prod", "", "", "alice"), nil)
+
+	// Latest moves to the previous snapshot, and further back on a second
+	// rollback while the original snapshot is remembered
+	assert.Equal(t, m.Rollback("latest", "", "broken bash", "alice"), nil)
+	assert.Equal(t, m.Rollback("latest", "", "broken bash", "alice"), nil)
+
+	latest, _ := m.publishedSnapshot("latest")
+	assert.Equal(t, latest, "20220101")
+
+	r, err := m.rollback("latest")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.From, "20220103")
+	assert.Equal(t, r.By, "alice")
+
+	assert.NotEqual(t, m.Rollback("latest", "", "", "alice"), nil)
+
+	// A rolled back channel can not be promoted until the rollback is cleared
+	assert.Equal(t, m.Promote("prod", "20220103", "bob"), nil)
+	assert.Equal(t, m.Rollback("prod", "20220101", "", "bob"), nil)
+	assert.NotEqual(t, m.Promote("prod", "20220103", "bob"), nil)
+
+	assert.Equal(t, testutil.ToFloat64(m.metrics.Rollbacks), float64(2))
+
+	assert.Equal(t, m.ClearRollback("prod"), nil)
+	assert.NotEqual(t, m.ClearRollback("prod"), nil)
+	assert.Equal(t, testutil.ToFloat64(m.metrics.Rollbacks), float64(1))
+	assert.Equal(t, m.Promote("prod", "20220103", "bob"), nil)
+}
+
+func TestPublishSnapshotRolledBack(t *testing.T) {
+	m := newChannelTestRepo(t)
+	m.remote = remote.NewDummyRemote("repo1", m.usPath)
+
+	assert.Equal(t, m.Rollback("latest", "20220101", "broken bash", "alice"), nil)
+
+	if err := os.MkdirAll(filepath.Join(m.saPath, "20220103"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot is published but latest is held
+	assert.Equal(t, m.publishSnapshot(context.Background(), "20220103"), nil)
+
+	published, _ := m.publishedSnapshot("20220103")
+	assert.Equal(t, published, "20220103")
+
+	latest, _ := m.publishedSnapshot("latest")
+	assert.Equal(t, latest, "20220101")
+
+	assert.Equal(t, m.ClearRollback("latest"), nil)
+
+	if err := os.MkdirAll(filepath.Join(m.saPath, "20220104"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, m.publishSnapshot(context.Background(), "20220104"), nil)
+
+	latest, _ = m.publishedSnapshot("latest")
+	assert.Equal(t, latest, "20220104")
+}
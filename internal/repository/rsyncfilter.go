@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+// Short names of the supported rsync filter rules by long name
+var filterRules = map[string]string{
+	"include":   "+",
+	"exclude":   "-",
+	"protect":   "P",
+	"risk":      "R",
+	"hide":      "H",
+	"show":      "S",
+	"merge":     ".",
+	"dir-merge": ":",
+	"clear":     "!",
+}
+
+// Modifiers allowed after the rule name, see FILTER RULES in rsync(1)
+const (
+	filterModifiers = "/!Csrpx"
+	mergeModifiers  = "-+Cenw" + filterModifiers
+)
+
+// parseFilterRule splits an rsync filter rule in long or short form, e.g.
+// "exclude,/ *.iso" or "-/ *.iso", into its long name, modifiers and pattern
+func parseFilterRule(rule string) (string, string, string, error) {
+	if strings.ContainsAny(rule, "\r\n") {
+		return "", "", "", errors.New("filter rule must be a single line")
+	}
+
+	head, arg, _ := strings.Cut(rule, " ")
+
+	var name, modifiers string
+
+	if long, mods, ok := strings.Cut(head, ","); ok && filterRules[long] != "" {
+		name, modifiers = long, mods
+	} else if filterRules[head] != "" {
+		name = head
+	} else {
+		for long, short := range filterRules {
+			if strings.HasPrefix(head, short) {
+				name, modifiers = long, strings.TrimPrefix(strings.TrimPrefix(head, short), ",")
+			}
+		}
+
+		if name == "" {
+			return "", "", "", errors.Errorf("unknown filter rule %q", head)
+		}
+	}
+
+	return name, modifiers, arg, nil
+}
+
+// checkFilterRule returns an error when rsync would reject the filter rule
+func checkFilterRule(rule string) error {
+	name, modifiers, arg, err := parseFilterRule(rule)
+	if err != nil {
+		return err
+	}
+
+	if name == "clear" {
+		if modifiers != "" || arg != "" {
+			return errors.New("clear takes no modifiers or pattern")
+		}
+
+		return nil
+	}
+
+	allowed := filterModifiers
+	if name == "merge" || name == "dir-merge" {
+		allowed = mergeModifiers
+	}
+
+	for _, m := range modifiers {
+		if !strings.ContainsRune(allowed, m) {
+			return errors.Errorf("invalid modifier %q for %s", m, name)
+		}
+	}
+
+	if strings.TrimSpace(arg) == "" {
+		return errors.Errorf("%s requires a pattern or file", name)
+	}
+
+	// rsync reads merge files relative to its working directory
+	if name == "merge" && !filepath.IsAbs(arg) {
+		return errors.Errorf("merge file %s must be an absolute path", arg)
+	}
+
+	return nil
+}
+
+func ValidateFilter(fl validator.FieldLevel) bool {
+	return checkFilterRule(fl.Field().String()) == nil
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"testing"
+
+	. "github.com/go-playground/assert/v2"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestValidateFilter(t *testing.T) {
+	validate := validator.New()
+	validate.RegisterValidation("rsync_filter", ValidateFilter)
+
+	var tests = []struct {
+		input string
+		valid bool
+	}{
+		{"include /7/", true},
+		{"include /7/os/x86_64/***", true},
+		{"exclude *", true},
+		{"exclude,/ /srv/mirror/*.iso", true},
+		{"+ /7/", true},
+		{"- *", true},
+		{"-/ *.iso", true},
+		{"P /local/", true},
+		{"protect /local/", true},
+		{"H .*", true},
+		{"merge /etc/lagoon/centos.rules", true},
+		{". /etc/lagoon/centos.rules", true},
+		{"dir-merge .rsync-filter", true},
+		{":n- .rsync-filter", true},
+		{"clear", true},
+		{"!", true},
+
+		{"", false},
+		{"include", false},
+		{"exclude ", false},
+		{"excludes *.iso", false},
+		{"Protect /local/", false},
+		{"-q *.iso", false},
+		{"merge centos.rules", false},
+		{". centos.rules", false},
+		{"clear *", false},
+		{"exclude *.iso\n+ /7/", false},
+	}
+	for i, test := range tests {
+		errs := validate.Var(test.input, "rsync_filter")
+
+		if test.valid {
+			if !IsEqual(errs, nil) {
+				t.Errorf("Test: %d with valid input should not result in error: %s", i, errs)
+			}
+		} else {
+			if IsEqual(errs, nil) {
+				t.Errorf("Test: %d with invalid input should result in error", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/klaasjand/lagoon/internal/gpg"
+	"github.com/klaasjand/lagoon/internal/secret"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// The public key is published in the root of each signed snapshot
+const signingKeyFile = "signing-key.asc"
+
+// SigningConfig holds the organisation key published snapshots are signed
+// with, upstream signatures no longer apply once a repository is filtered
+type SigningConfig struct {
+	Keyring    string        `yaml:"keyring" validate:"omitempty,repo_path"`
+	KeyId      string        `yaml:"key_id" mapstructure:"key_id" validate:"omitempty,hexadecimal"`
+	Passphrase secret.Source `yaml:"passphrase"`
+}
+
+// signing returns the signing config of the repo, or the global one when the
+// repo has none
+func (m Repo) signing() SigningConfig {
+	if m.config.Signing.Keyring != "" {
+		return m.config.Signing
+	}
+
+	return m.global.Signing
+}
+
+// signingKey returns the decrypted signing key, or nil when signing is not
+// configured
+func (m Repo) signingKey() (*openpgp.Entity, error) {
+	cfg := m.signing()
+	if cfg.Keyring == "" {
+		return nil, nil
+	}
+
+	passphrase, err := cfg.Passphrase.Value()
+	if err != nil {
+		return nil, errors.Wrap(err, "passphrase")
+	}
+
+	return gpg.ReadSigningKey(cfg.Keyring, cfg.KeyId, passphrase)
+}
+
+// signSnapshot signs the repomd.xml and Release files of a snapshot which are
+// generated or filtered by Lagoon, and publishes the public key next to them
+func (m Repo) signSnapshot(ctx context.Context, snapshot string) error {
+	signer, err := m.signingKey()
+	if err != nil || signer == nil {
+		return err
+	}
+
+	snapPath := filepath.Join(m.saPath, snapshot)
+
+	files, err := findRepoFiles(snapPath)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files.repomds {
+		if err := signRepomd(signer, path); err != nil {
+			return errors.Wrapf(err, "unable to sign %s", path)
+		}
+	}
+
+	for _, dir := range files.releases {
+		if err := signRelease(signer, dir); err != nil {
+			return errors.Wrapf(err, "unable to sign %s", dir)
+		}
+	}
+
+	var key bytes.Buffer
+	if err := gpg.ExportPublicKey(&key, signer); err != nil {
+		return err
+	}
+
+	if err := replaceFile(filepath.Join(snapPath, signingKeyFile), key.Bytes()); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int("metadata", len(files.repomds)+len(files.releases)).
+		Str("key", signer.PrimaryKey.KeyIdString()).
+		Msg("Signed snapshot")
+
+	return nil
+}
+
+func signRepomd(signer *openpgp.Entity, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var sig bytes.Buffer
+	if err := gpg.SignDetached(&sig, signer, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	return replaceFile(path+".asc", sig.Bytes())
+}
+
+// signRelease writes the Release.gpg and InRelease of a Debian suite. When the
+// upstream only has an InRelease its content is used as the Release file.
+func signRelease(signer *openpgp.Entity, dir string) error {
+	release := filepath.Join(dir, "Release")
+
+	data, err := os.ReadFile(release)
+	if os.IsNotExist(err) {
+		inRelease, err := os.ReadFile(filepath.Join(dir, "InRelease"))
+		if err != nil {
+			return err
+		}
+
+		block, _ := clearsign.Decode(inRelease)
+		if block == nil {
+			return errors.New("no clear signed message found in InRelease")
+		}
+
+		data = block.Plaintext
+
+		if err := replaceFile(release, data); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	var sig bytes.Buffer
+	if err := gpg.SignDetached(&sig, signer, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	if err := replaceFile(release+".gpg", sig.Bytes()); err != nil {
+		return err
+	}
+
+	inRelease, err := gpg.ClearSign(signer, data)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(filepath.Join(dir, "InRelease"), inRelease)
+}
+
+// replaceFile writes data to a new file which replaces path, files in a
+// snapshot are hardlinks shared with the upstream tree and other snapshots
+// and must never be written in place
+func replaceFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/klaasjand/lagoon/internal/gpg"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestSignSnapshot(t *testing.T) {
+	dest := t.TempDir()
+
+	signer, err := openpgp.NewEntity("Lagoon Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var secring bytes.Buffer
+	w, _ := armor.Encode(&secring, openpgp.PrivateKeyType, nil)
+	signer.SerializePrivate(w, nil)
+	w.Close()
+
+	keyring := filepath.Join(dest, "secring.asc")
+	os.WriteFile(keyring, secring.Bytes(), 0600)
+
+	m := Repo{
+		config: RepoConfig{Id: "repo1", Dest: dest},
+		global: GlobalConfig{Signing: SigningConfig{Keyring: keyring}},
+		usPath: getUpstreamPath("repo1", dest),
+		saPath: getStagingPath("repo1", dest),
+	}
+
+	upstreamFiles := map[string]string{
+		"repodata/repomd.xml":             "<repomd/>",
+		"repodata/repomd.xml.asc":         "upstream signature",
+		"debian/dists/stable/Release":     "Suite: stable\n",
+		"debian/dists/stable/Release.gpg": "upstream signature",
+	}
+
+	for f, content := range upstreamFiles {
+		usFile := filepath.Join(m.usPath, f)
+		saFile := filepath.Join(m.saPath, "20220130", f)
+
+		os.MkdirAll(filepath.Dir(usFile), 0755)
+		os.MkdirAll(filepath.Dir(saFile), 0755)
+		os.WriteFile(usFile, []byte(content), 0644)
+
+		if err := os.Link(usFile, saFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, m.signSnapshot(context.Background(), "20220130"), nil)
+
+	// The upstream tree is shared through hardlinks and must be unchanged
+	for f, content := range upstreamFiles {
+		data, _ := os.ReadFile(filepath.Join(m.usPath, f))
+		assert.Equal(t, string(data), content)
+	}
+
+	snapPath := filepath.Join(m.saPath, "20220130")
+
+	published, err := gpg.ReadKeyring([]string{filepath.Join(snapPath, signingKeyFile)})
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, verifyRepomd(published, filepath.Join(snapPath, "repodata", "repomd.xml")), nil)
+	_, err = verifyRelease(published, filepath.Join(snapPath, "debian", "dists", "stable"))
+	assert.Equal(t, err, nil)
+
+	sig, _ := os.ReadFile(filepath.Join(snapPath, "debian", "dists", "stable", "Release.gpg"))
+	release, _ := os.ReadFile(filepath.Join(snapPath, "debian", "dists", "stable", "Release"))
+	assert.Equal(t, gpg.VerifyDetached(published, bytes.NewReader(release), sig), nil)
+}
+
+func TestSignSnapshotDisabled(t *testing.T) {
+	m := Repo{saPath: t.TempDir()}
+
+	assert.Equal(t, m.signSnapshot(context.Background(), "20220130"), nil)
+
+	_, err := os.Stat(filepath.Join(m.saPath, "20220130", signingKeyFile))
+	assert.Equal(t, os.IsNotExist(err), true)
+}
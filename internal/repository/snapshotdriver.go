@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot drivers
+const (
+	snapshotDriverHardlink = "hardlink"
+	snapshotDriverReflink  = "reflink"
+	snapshotDriverCopy     = "copy"
+)
+
+// SnapshotDriver creates a snapshot of the upstream tree
+type SnapshotDriver interface {
+	// Snapshot recreates the tree at src at dest, which must not exist
+	Snapshot(src string, dest string) error
+	// Probe checks that the driver works from the filesystem of src to the
+	// filesystem of dest
+	Probe(src string, dest string) error
+}
+
+func newSnapshotDriver(name string) (SnapshotDriver, error) {
+	switch name {
+	case "", snapshotDriverHardlink:
+		return treeDriver{name: snapshotDriverHardlink, file: linkFile}, nil
+	case snapshotDriverReflink:
+		return treeDriver{name: snapshotDriverReflink, file: reflinkFile}, nil
+	case snapshotDriverCopy:
+		return treeDriver{name: snapshotDriverCopy, file: copyFile}, nil
+	default:
+		return nil, errors.Errorf("unknown snapshot driver '%s'", name)
+	}
+}
+
+// treeDriver recreates the folders and symlinks of a tree and hands its
+// regular files to file
+type treeDriver struct {
+	name string
+	file func(src string, dest string, info fs.FileInfo) error
+}
+
+func (d treeDriver) Snapshot(src string, dest string) error {
+	src = filepath.Clean(src)
+
+	// Folder times are restored after their content is created
+	type dirTimes struct {
+		path  string
+		mtime time.Time
+	}
+
+	var dirs []dirTimes
+
+	err := filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+
+			// Mkdir is subject to the umask
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+
+			dirs = append(dirs, dirTimes{target, info.ModTime()})
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := d.file(path, target, info); err != nil {
+				return errors.Wrapf(err, "unable to %s %s", d.name, path)
+			}
+		default:
+			// Devices, sockets and pipes have no place in a repository
+			return nil
+		}
+
+		return preserveOwner(target, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d treeDriver) Probe(src string, dest string) error {
+	f, err := os.CreateTemp(src, ".probe-")
+	if err != nil {
+		return err
+	}
+
+	probe := f.Name()
+	defer os.Remove(probe)
+
+	_, err = f.WriteString("lagoon")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(probe)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(dest, filepath.Base(probe))
+	defer os.Remove(target)
+
+	if err := d.file(probe, target, info); err != nil {
+		return errors.Wrapf(err, "%s from %s to %s is not supported", d.name, src, dest)
+	}
+
+	return nil
+}
+
+func linkFile(src string, dest string, info fs.FileInfo) error {
+	return os.Link(src, dest)
+}
+
+// cloneFile creates dest with the mode and modification time of src and fills
+// it with clone
+func cloneFile(src string, dest string, info fs.FileInfo, clone func(in *os.File, out *os.File) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err := clone(in, out); err != nil {
+		out.Close()
+		os.Remove(dest)
+
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dest, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+func copyFile(src string, dest string, info fs.FileInfo) error {
+	return cloneFile(src, dest, info, func(in *os.File, out *os.File) error {
+		_, err := io.Copy(out, in)
+
+		return err
+	})
+}
+
+func reflinkFile(src string, dest string, info fs.FileInfo) error {
+	return cloneFile(src, dest, info, reflink)
+}
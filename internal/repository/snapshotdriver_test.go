@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func writeDriverTestTree(t *testing.T, src string) {
+	for _, dir := range []string{"Packages", "repodata"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "Packages", "bash-5.1-1.x86_64.rpm"), []byte("bash"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("../Packages", filepath.Join(src, "repodata", "packages")); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "Packages"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotDrivers(t *testing.T) {
+	for _, name := range []string{snapshotDriverHardlink, snapshotDriverCopy, snapshotDriverReflink} {
+		src := t.TempDir()
+		dest := filepath.Join(t.TempDir(), "20220101")
+
+		writeDriverTestTree(t, src)
+
+		driver, err := newSnapshotDriver(name)
+		assert.Equal(t, err, nil)
+
+		if err := driver.Probe(src, filepath.Dir(dest)); err != nil {
+			// Reflinks depend on the filesystem of the test host
+			if name == snapshotDriverReflink {
+				continue
+			}
+
+			t.Fatalf("Probe of %s failed: %v", name, err)
+		}
+
+		assert.Equal(t, driver.Snapshot(src, dest), nil)
+
+		pkg := filepath.Join("Packages", "bash-5.1-1.x86_64.rpm")
+
+		data, err := os.ReadFile(filepath.Join(dest, pkg))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, string(data), "bash")
+
+		srcInfo, _ := os.Stat(filepath.Join(src, pkg))
+		destInfo, _ := os.Stat(filepath.Join(dest, pkg))
+		assert.Equal(t, destInfo.Mode().Perm(), os.FileMode(0640))
+		assert.Equal(t, os.SameFile(srcInfo, destInfo), name == snapshotDriverHardlink)
+
+		if nlink, ok := linkCount(destInfo); ok && name == snapshotDriverHardlink {
+			assert.Equal(t, nlink, uint64(2))
+		}
+
+		link, err := os.Readlink(filepath.Join(dest, "repodata", "packages"))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, link, "../Packages")
+
+		dirInfo, _ := os.Stat(filepath.Join(dest, "Packages"))
+		assert.Equal(t, dirInfo.ModTime().Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)), true)
+
+		// Probe files are cleaned up
+		entries, _ := os.ReadDir(src)
+		assert.Equal(t, len(entries), 2)
+	}
+}
+
+func TestSnapshotDriverError(t *testing.T) {
+	src := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "20220101")
+
+	writeDriverTestTree(t, src)
+
+	driver := treeDriver{name: "copy", file: func(string, string, fs.FileInfo) error {
+		return errors.New("no space left on device")
+	}}
+
+	// The error names the file which failed
+	err := driver.Snapshot(src, dest)
+	assert.Equal(t, err.Error(), "unable to copy "+filepath.Join(src, "Packages", "bash-5.1-1.x86_64.rpm")+": no space left on device")
+
+	_, err = newSnapshotDriver("rsync")
+	assert.NotEqual(t, err, nil)
+}
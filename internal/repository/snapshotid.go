@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot id formats, daily ids are the default and sequence ids number the
+// snapshots of a day, e.g. 20060102.001
+const (
+	snapshotFormatDaily    = "daily"
+	snapshotFormatMinute   = "minute"
+	snapshotFormatSecond   = "second"
+	snapshotFormatSequence = "sequence"
+)
+
+const fmtSnapshotLayout = "20060102"
+
+var snapshotLayouts = map[string]string{
+	snapshotFormatDaily:    fmtSnapshotLayout,
+	snapshotFormatMinute:   fmtSnapshotLayout + "T1504",
+	snapshotFormatSecond:   fmtSnapshotLayout + "T150405",
+	snapshotFormatSequence: fmtSnapshotLayout,
+}
+
+// Matches a date in YYYYMMDD format from 19000101 through 20991231
+const fmtSnapshotPattern = `(19|20)\d\d(0[1-9]|1[012])(0[1-9]|[12][0-9]|3[01])`
+
+// Matches the ids of all formats, so snapshots are still recognised after the
+// format of a repo is changed
+var snapshotIdRegexp = regexp.MustCompile(`^` + fmtSnapshotPattern + `(T([01]\d|2[0-3])[0-5]\d([0-5]\d)?|\.(\d{3,}))?$`)
+
+// isSnapshotId reports whether name is a snapshot id of any format
+func isSnapshotId(name string) bool {
+	return snapshotIdRegexp.MatchString(name)
+}
+
+// newSnapshotId returns the id of a snapshot created at t, the existing
+// snapshots are needed to number sequence ids
+func newSnapshotId(format string, utc bool, t time.Time, existing []string) string {
+	if utc {
+		t = t.UTC()
+	}
+
+	if format == "" {
+		format = snapshotFormatDaily
+	}
+
+	id := t.Format(snapshotLayouts[format])
+
+	if format != snapshotFormatSequence {
+		return id
+	}
+
+	seq := 0
+	for _, s := range existing {
+		if date, n, ok := strings.Cut(s, "."); ok && date == id {
+			if i, err := strconv.Atoi(n); err == nil && i > seq {
+				seq = i
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s.%03d", id, seq+1)
+}
+
+// snapshotIdLess orders snapshot ids by the time they represent, sequence ids
+// by their number within the day
+func snapshotIdLess(a string, b string) bool {
+	aTime, aSeq := parseSnapshotId(a)
+	bTime, bSeq := parseSnapshotId(b)
+
+	if !aTime.Equal(bTime) {
+		return aTime.Before(bTime)
+	}
+
+	if aSeq != bSeq {
+		return aSeq < bSeq
+	}
+
+	return a < b
+}
+
+// parseSnapshotId returns the time and sequence number of a snapshot id, the
+// time zone the id was created in is unknown so all are read as UTC
+func parseSnapshotId(id string) (time.Time, int) {
+	m := snapshotIdRegexp.FindStringSubmatch(id)
+	if m == nil {
+		return time.Time{}, 0
+	}
+
+	layout := fmtSnapshotLayout
+	value := id
+
+	switch {
+	case m[7] != "":
+		value = id[:len(fmtSnapshotLayout)]
+	case len(id) == len(snapshotLayouts[snapshotFormatMinute]):
+		layout = snapshotLayouts[snapshotFormatMinute]
+	case len(id) == len(snapshotLayouts[snapshotFormatSecond]):
+		layout = snapshotLayouts[snapshotFormatSecond]
+	}
+
+	t, _ := time.Parse(layout, value)
+	seq, _ := strconv.Atoi(m[7])
+
+	return t, seq
+}
+
+// snapshotIdClock returns the wall clock time of t in UTC or local time as
+// read by parseSnapshotId
+func snapshotIdClock(t time.Time, utc bool) time.Time {
+	if utc {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// sortSnapshotIds sorts snapshot ids from oldest to newest
+func sortSnapshotIds(ids []string) {
+	sort.SliceStable(ids, func(i, j int) bool {
+		return snapshotIdLess(ids[i], ids[j])
+	})
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestNewSnapshotId(t *testing.T) {
+	local := time.FixedZone("CEST", 2*60*60)
+	now := time.Date(2022, 1, 2, 0, 30, 15, 0, local)
+
+	var tests = []struct {
+		format   string
+		utc      bool
+		existing []string
+		id       string
+	}{
+		{"", false, nil, "20220102"},
+		{snapshotFormatDaily, true, nil, "20220101"},
+		{snapshotFormatMinute, false, nil, "20220102T0030"},
+		{snapshotFormatMinute, true, nil, "20220101T2230"},
+		{snapshotFormatSecond, false, nil, "20220102T003015"},
+		{snapshotFormatSequence, false, nil, "20220102.001"},
+		{snapshotFormatSequence, false, []string{"20220101.007", "20220102", "20220102.001", "20220102.002"}, "20220102.003"},
+	}
+	for i, test := range tests {
+		if id := newSnapshotId(test.format, test.utc, now, test.existing); id != test.id {
+			t.Errorf("Test: %d expected %s, got %s", i, test.id, id)
+		}
+	}
+}
+
+func TestIsSnapshotId(t *testing.T) {
+	var tests = []struct {
+		input string
+		valid bool
+	}{
+		{"20220102", true},
+		{"20220102T0030", true},
+		{"20220102T003015", true},
+		{"20220102.001", true},
+		{"20220102.1000", true},
+		{"20221302", false},
+		{"20220102T2460", false},
+		{"20220102.1", false},
+		{"20220102.json", false},
+		{"latest", false},
+		{".incoming", false},
+	}
+	for i, test := range tests {
+		if test.valid != isSnapshotId(test.input) {
+			t.Errorf("Test: %d expected %s valid to be %v", i, test.input, test.valid)
+		}
+	}
+}
+
+func TestSortSnapshotIds(t *testing.T) {
+	ids := []string{"20220102.010", "20220103", "20220102T1200", "20220102.002", "20211231", "20220102"}
+
+	sortSnapshotIds(ids)
+
+	assert.Equal(t, ids, []string{"20211231", "20220102", "20220102.002", "20220102.010", "20220102T1200", "20220103"})
+}
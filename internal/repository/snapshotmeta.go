@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klaasjand/lagoon/internal/remote"
+)
+
+// SnapshotMetadata describes how a snapshot was created, it is stored next to
+// the snapshot in the staging folder
+type SnapshotMetadata struct {
+	Snapshot         string    `json:"snapshot"`
+	Created          time.Time `json:"created"`
+	JobId            string    `json:"jobid"`
+	Mirror           string    `json:"mirror,omitempty"`
+	FilesAdded       int       `json:"files_added"`
+	FilesUpdated     int       `json:"files_updated"`
+	FilesDeleted     int       `json:"files_deleted"`
+	FilesKept        int       `json:"files_kept,omitempty"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	TotalSize        int64     `json:"total_size"`
+}
+
+func (m Repo) snapshotMetadataPath(snapshot string) string {
+	return filepath.Join(m.saPath, snapshot+".json")
+}
+
+// writeSnapshotMetadata records the sync result, the total size is taken from
+// the published snapshot as it may differ from upstream
+func (m Repo) writeSnapshotMetadata(snapshot string, jobId string, result remote.SyncResult) error {
+	size, err := treeSize(filepath.Join(m.saPath, snapshot))
+	if err != nil {
+		return err
+	}
+
+	meta := SnapshotMetadata{
+		Snapshot:         snapshot,
+		Created:          time.Now(),
+		JobId:            jobId,
+		Mirror:           result.Mirror,
+		FilesAdded:       result.FilesAdded,
+		FilesUpdated:     result.FilesUpdated,
+		FilesDeleted:     result.FilesDeleted,
+		FilesKept:        result.FilesKept,
+		BytesTransferred: result.BytesTransferred,
+		TotalSize:        size,
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(m.snapshotMetadataPath(snapshot), data, 0644)
+}
+
+func (m Repo) removeSnapshotMetadata(snapshot string) error {
+	if err := os.Remove(m.snapshotMetadataPath(snapshot)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// treeSize returns the size of all regular files below root
+func treeSize(root string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
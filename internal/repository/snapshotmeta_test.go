@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/klaasjand/lagoon/internal/remote"
+)
+
+func TestWriteSnapshotMetadata(t *testing.T) {
+	m := Repo{saPath: t.TempDir()}
+
+	writeDriverTestTree(t, filepath.Join(m.saPath, "20220101"))
+
+	// The upstream size includes packages which were pruned from the snapshot
+	result := remote.SyncResult{FilesAdded: 2, TotalSize: 1024}
+	if err := m.writeSnapshotMetadata("20220101", "job", result); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(m.snapshotMetadataPath("20220101"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var meta SnapshotMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, meta.JobId, "job")
+	assert.Equal(t, meta.FilesAdded, 2)
+	assert.Equal(t, meta.TotalSize, int64(len("bash")))
+}
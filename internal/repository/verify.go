@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klaasjand/lagoon/internal/gpg"
+	"github.com/klaasjand/lagoon/internal/remote"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/openpgp"
+)
+
+// VerifyConfig holds the keyrings the upstream metadata and packages must be
+// signed with before a snapshot is created
+type VerifyConfig struct {
+	Keyrings []string `yaml:"keyrings" validate:"required_with=Metadata Packages,dive,repo_path"`
+	Metadata bool     `yaml:"metadata"`
+	Packages bool     `yaml:"packages"`
+}
+
+func (c VerifyConfig) enabled() bool {
+	return c.Metadata || c.Packages
+}
+
+// repoFiles holds the signed files of a repository tree, the repomd.xml of RPM
+// repositories, the dists folders of Debian repositories and RPM packages
+type repoFiles struct {
+	repomds  []string
+	releases []string
+	packages []string
+}
+
+func findRepoFiles(root string) (repoFiles, error) {
+	var files repoFiles
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		dir := filepath.Dir(path)
+
+		switch {
+		case d.Name() == "repomd.xml" && filepath.Base(dir) == "repodata":
+			files.repomds = append(files.repomds, path)
+		case (d.Name() == "Release" || d.Name() == "InRelease") && filepath.Base(filepath.Dir(dir)) == "dists":
+			// A suite usually has both, it only needs to be verified once
+			if n := len(files.releases); n == 0 || files.releases[n-1] != dir {
+				files.releases = append(files.releases, dir)
+			}
+		case strings.HasSuffix(d.Name(), ".rpm"):
+			files.packages = append(files.packages, path)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+func verifyRepomd(keyring openpgp.KeyRing, path string) error {
+	sig, err := os.ReadFile(path + ".asc")
+	if err != nil {
+		return errors.Wrapf(err, "missing signature of %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(gpg.VerifyDetached(keyring, bytes.NewReader(data), sig), "invalid signature of %s", path)
+}
+
+// verifyRelease verifies the InRelease file of a Debian suite and the Release
+// file with its detached signature, a suite needs at least one of them. It
+// returns the verified content of the Release file, or of the InRelease when
+// there is no Release, as the checksums of the suite are read from it.
+func verifyRelease(keyring openpgp.KeyRing, dir string) ([]byte, error) {
+	inRelease := filepath.Join(dir, "InRelease")
+
+	var verified []byte
+
+	if data, err := os.ReadFile(inRelease); err == nil {
+		if verified, err = gpg.VerifyClearSigned(keyring, data); err != nil {
+			return nil, errors.Wrapf(err, "invalid signature of %s", inRelease)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	release := filepath.Join(dir, "Release")
+
+	data, err := os.ReadFile(release)
+	if os.IsNotExist(err) && verified != nil {
+		return verified, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A Release next to an InRelease is not covered by its signature
+	sig, err := os.ReadFile(release + ".gpg")
+	if err != nil {
+		return nil, errors.Wrapf(err, "missing signature of %s", release)
+	}
+
+	if err := gpg.VerifyDetached(keyring, bytes.NewReader(data), sig); err != nil {
+		return nil, errors.Wrapf(err, "invalid signature of %s", release)
+	}
+
+	return data, nil
+}
+
+// keptFiles returns whether a file of the upstream tree is only there because
+// it is kept after it was deleted upstream, it was verified before it was
+// deleted
+func (m Repo) keptFiles() (func(path string) bool, error) {
+	files, err := remote.KeptFiles(m.statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := map[string]bool{}
+	for _, f := range files {
+		kept[filepath.Join(m.usPath, f)] = true
+	}
+
+	return func(path string) bool { return kept[path] }, nil
+}
+
+// verifyUpstream checks the signatures of the upstream tree against the
+// configured keyrings, so a compromised mirror cannot inject RPM or Debian
+// binary packages into a snapshot. Debian source packages are not checked.
+func (m Repo) verifyUpstream(ctx context.Context) error {
+	cfg := m.config.Verify
+	if !cfg.enabled() {
+		return nil
+	}
+
+	keyring, err := gpg.ReadKeyring(cfg.Keyrings)
+	if err != nil {
+		return err
+	}
+
+	files, err := findRepoFiles(m.usPath)
+	if err != nil {
+		return err
+	}
+
+	verifiedMetadata, verifiedPackages := 0, 0
+
+	if cfg.Metadata {
+		if len(files.repomds) == 0 && len(files.releases) == 0 {
+			return errors.New("no repository metadata found to verify")
+		}
+
+		kept, err := m.keptFiles()
+		if err != nil {
+			return err
+		}
+
+		// The signatures only cover the repomd.xml and Release files, the
+		// checksums listed in those cover the rest of the tree
+		for _, path := range files.repomds {
+			if err := verifyRepomd(keyring, path); err != nil {
+				return err
+			}
+
+			if err := remote.CheckSignedRepomd(ctx, filepath.Dir(filepath.Dir(path)), kept); err != nil {
+				return errors.Wrapf(err, "upstream does not match the signed %s", path)
+			}
+		}
+
+		releases := map[string][]byte{}
+
+		for _, dir := range files.releases {
+			release, err := verifyRelease(keyring, dir)
+			if err != nil {
+				return err
+			}
+
+			releases[dir] = release
+		}
+
+		if len(releases) > 0 {
+			if err := remote.CheckSignedReleases(ctx, m.usPath, releases, kept); err != nil {
+				return errors.Wrap(err, "upstream does not match the signed Release files")
+			}
+		}
+
+		verifiedMetadata = len(files.repomds) + len(files.releases)
+	}
+
+	if cfg.Packages {
+		failed := 0
+
+		var firstErr error
+
+		for _, path := range files.packages {
+			if err := gpg.VerifyRPM(keyring, path); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("package", path).Msg("Package verification failed")
+
+				if firstErr == nil {
+					firstErr = errors.Wrapf(err, "invalid package %s", path)
+				}
+
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return errors.Wrapf(firstErr, "%d of %d packages failed verification", failed, len(files.packages))
+		}
+
+		verifiedPackages = len(files.packages)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int("metadata", verifiedMetadata).
+		Int("packages", verifiedPackages).
+		Msg("Verified upstream signatures")
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/klaasjand/lagoon/internal/gpg"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestFindRepoFiles(t *testing.T) {
+	root := t.TempDir()
+
+	for _, f := range []string{
+		"8/BaseOS/x86_64/os/repodata/repomd.xml",
+		"8/BaseOS/x86_64/os/repodata/repomd.xml.asc",
+		"8/BaseOS/x86_64/os/Packages/b/bash-4.4.20-4.el8.x86_64.rpm",
+		"debian/dists/bookworm/InRelease",
+		"debian/dists/bookworm/Release",
+		"debian/dists/bookworm/Release.gpg",
+		"debian/dists/bookworm/main/binary-amd64/Release",
+		"debian/pool/main/b/bash/bash_5.2.15-2_amd64.deb",
+	} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := findRepoFiles(root)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, files.repomds, []string{filepath.Join(root, "8/BaseOS/x86_64/os/repodata/repomd.xml")})
+	assert.Equal(t, files.releases, []string{filepath.Join(root, "debian/dists/bookworm")})
+	assert.Equal(t, files.packages, []string{filepath.Join(root, "8/BaseOS/x86_64/os/Packages/b/bash-4.4.20-4.el8.x86_64.rpm")})
+}
+
+func TestVerifyUpstream(t *testing.T) {
+	dest := t.TempDir()
+
+	signer, err := openpgp.NewEntity("Upstream Test", "", "upstream@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pubring bytes.Buffer
+	signer.Serialize(&pubring)
+
+	keyring := filepath.Join(dest, "pubring.gpg")
+	os.WriteFile(keyring, pubring.Bytes(), 0644)
+
+	m := Repo{
+		config:    RepoConfig{Id: "repo1", Dest: dest, Verify: VerifyConfig{Keyrings: []string{keyring}, Metadata: true}},
+		usPath:    getUpstreamPath("repo1", dest),
+		statePath: getStatePath("repo1", dest),
+	}
+
+	sha256Hex := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+
+		return hex.EncodeToString(sum[:])
+	}
+
+	writeFile := func(f string, content string) {
+		path := filepath.Join(m.usPath, f)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	primary := fmt.Sprintf(`<metadata packages="1">
+  <package type="rpm"><name>bash</name><checksum type="sha256">%s</checksum><size package="4"/><location href="Packages/bash-5.1-1.x86_64.rpm"/></package>
+</metadata>`, sha256Hex("bash"))
+	repomd := fmt.Sprintf(`<repomd><data type="primary"><checksum type="sha256">%s</checksum><location href="repodata/primary.xml"/></data></repomd>`, sha256Hex(primary))
+
+	var sig bytes.Buffer
+	if err := gpg.SignDetached(&sig, signer, bytes.NewReader([]byte(repomd))); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile("repodata/repomd.xml", repomd)
+	writeFile("repodata/repomd.xml.asc", sig.String())
+	writeFile("repodata/primary.xml", primary)
+	writeFile("Packages/bash-5.1-1.x86_64.rpm", "bash")
+
+	assert.Equal(t, m.verifyUpstream(context.Background()), nil)
+
+	// A package replaced by a mirror, the signed repomd.xml is unchanged
+	writeFile("Packages/bash-5.1-1.x86_64.rpm", "evil")
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+
+	// A package injected by a mirror
+	writeFile("Packages/bash-5.1-1.x86_64.rpm", "bash")
+	writeFile("Packages/evil-1.0-1.x86_64.rpm", "evil")
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+
+	// Unless it is kept after it was deleted upstream
+	os.MkdirAll(m.statePath, 0755)
+	os.WriteFile(filepath.Join(m.statePath, "deleted.json"), []byte(`{"Packages/evil-1.0-1.x86_64.rpm": "2022-01-01T00:00:00Z"}`), 0644)
+	assert.Equal(t, m.verifyUpstream(context.Background()), nil)
+
+	// Metadata replaced by a mirror
+	writeFile("repodata/primary.xml", "<metadata/>")
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+}
+
+func TestVerifyUpstreamDebian(t *testing.T) {
+	dest := t.TempDir()
+
+	signer, err := openpgp.NewEntity("Upstream Test", "", "upstream@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pubring bytes.Buffer
+	signer.Serialize(&pubring)
+
+	keyring := filepath.Join(dest, "pubring.gpg")
+	os.WriteFile(keyring, pubring.Bytes(), 0644)
+
+	m := Repo{
+		config:    RepoConfig{Id: "repo1", Dest: dest, Verify: VerifyConfig{Keyrings: []string{keyring}, Metadata: true}},
+		usPath:    getUpstreamPath("repo1", dest),
+		statePath: getStatePath("repo1", dest),
+	}
+
+	sha256Hex := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+
+		return hex.EncodeToString(sum[:])
+	}
+
+	writeFile := func(f string, content string) {
+		path := filepath.Join(m.usPath, f)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	packages := fmt.Sprintf("Package: bash\nFilename: pool/main/b/bash/bash_5.2-2_amd64.deb\nSize: 4\nSHA256: %s\n", sha256Hex("bash"))
+	release := fmt.Sprintf("Suite: stable\nSHA256:\n %s %d main/binary-amd64/Packages\n", sha256Hex(packages), len(packages))
+
+	inRelease, err := gpg.ClearSign(signer, []byte(release))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sig bytes.Buffer
+	if err := gpg.SignDetached(&sig, signer, bytes.NewReader([]byte(release))); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile("dists/stable/InRelease", string(inRelease))
+	writeFile("dists/stable/main/binary-amd64/Packages", packages)
+	writeFile("pool/main/b/bash/bash_5.2-2_amd64.deb", "bash")
+
+	assert.Equal(t, m.verifyUpstream(context.Background()), nil)
+
+	// A Release next to the InRelease needs its own signature
+	writeFile("dists/stable/Release", release)
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+
+	writeFile("dists/stable/Release.gpg", sig.String())
+	assert.Equal(t, m.verifyUpstream(context.Background()), nil)
+
+	// A Release changed by a mirror which kept the InRelease
+	writeFile("dists/stable/Release", release+"Label: evil\n")
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+	writeFile("dists/stable/Release", release)
+
+	// A package replaced by a mirror
+	writeFile("pool/main/b/bash/bash_5.2-2_amd64.deb", "evil")
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+
+	// A package injected by a mirror
+	writeFile("pool/main/b/bash/bash_5.2-2_amd64.deb", "bash")
+	writeFile("pool/main/e/evil/evil_1.0-1_amd64.deb", "evil")
+	assert.NotEqual(t, m.verifyUpstream(context.Background()), nil)
+}
@@ -0,0 +1,106 @@
+package secret
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const redacted = "********"
+
+var (
+	mu      sync.RWMutex
+	secrets = map[string]bool{}
+)
+
+// Source describes where a secret is read from, either a file or an
+// environment variable
+type Source struct {
+	File string `yaml:"file" validate:"omitempty,repo_path"`
+	Env  string `yaml:"env"`
+}
+
+func (s Source) IsSet() bool {
+	return s.File != "" || s.Env != ""
+}
+
+// Value reads the secret and registers it for redaction
+func (s Source) Value() (string, error) {
+	var value string
+
+	switch {
+	case s.File != "" && s.Env != "":
+		return "", errors.New("secret must be read from either a file or an environment variable")
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to read secret")
+		}
+
+		value = strings.TrimRight(string(data), "\r\n")
+	case s.Env != "":
+		var ok bool
+
+		if value, ok = os.LookupEnv(s.Env); !ok {
+			return "", errors.Errorf("environment variable %s is not set", s.Env)
+		}
+	default:
+		return "", nil
+	}
+
+	Register(value)
+
+	return value, nil
+}
+
+// Register adds a value which must never appear in logs
+func Register(value string) {
+	if value == "" {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	secrets[value] = true
+}
+
+// Redact replaces all registered secrets in s, including their JSON escaped
+// form as it appears in structured logs
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for value := range secrets {
+		s = strings.ReplaceAll(s, value, redacted)
+
+		if escaped, err := json.Marshal(value); err == nil {
+			s = strings.ReplaceAll(s, string(escaped[1:len(escaped)-1]), redacted)
+		}
+	}
+
+	return s
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewWriter returns a writer which redacts all registered secrets before
+// writing to w
+func NewWriter(w io.Writer) io.Writer {
+	return redactingWriter{w: w}
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+
+	// Report the original length, the redacted output may differ in size
+	return len(p), nil
+}
@@ -0,0 +1,70 @@
+package secret
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestSourceValue(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LAGOON_TEST_SECRET", "from-env")
+
+	var tests = []struct {
+		source   Source
+		expected string
+		valid    bool
+	}{
+		{Source{}, "", true},
+		{Source{File: file}, "from-file", true},
+		{Source{Env: "LAGOON_TEST_SECRET"}, "from-env", true},
+		{Source{File: filepath.Join(dir, "missing")}, "", false},
+		{Source{Env: "LAGOON_TEST_SECRET_MISSING"}, "", false},
+		{Source{File: file, Env: "LAGOON_TEST_SECRET"}, "", false},
+	}
+	for i, test := range tests {
+		value, err := test.source.Value()
+
+		if test.valid {
+			if err != nil {
+				t.Errorf("Test: %d with valid input should not result in error: %s", i, err)
+			}
+			if value != test.expected {
+				t.Errorf("Test: %d expected %s, got %s", i, test.expected, value)
+			}
+		} else if err == nil {
+			t.Errorf("Test: %d with invalid input should result in error", i)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	Register("s3cr3t")
+	Register(`pa"ss`)
+
+	assert.Equal(t, Redact("password=s3cr3t"), "password=********")
+	assert.Equal(t, Redact(`{"message":"pa\"ss"}`), `{"message":"********"}`)
+	assert.Equal(t, Redact("nothing to hide"), "nothing to hide")
+}
+
+func TestWriter(t *testing.T) {
+	Register("t0ken")
+
+	var buf bytes.Buffer
+	line := []byte("Authorization: Bearer t0ken\n")
+
+	n, err := NewWriter(&buf).Write(line)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, len(line))
+	assert.Equal(t, buf.String(), "Authorization: Bearer ********\n")
+}